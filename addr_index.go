@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const addrIndexBucket = "addrindex"
+
+// addrIndex records, for every public key or script hash, the transactions
+// of the active chain that paid to it or spent from it. Keys are
+// hash || height || position so a cursor walks the history of a hash in
+// chain order; values are txid || received || sent
+type addrIndex struct{}
+
+// addrHistoryEntry is one transaction of the history of an address
+type addrHistoryEntry struct {
+	TxID     []byte
+	Height   int
+	Received int
+	Sent     int
+}
+
+func (addrIndex) name() string {
+	return addrIndexBucket
+}
+
+func (addrIndex) connect(tx StoreTx, b *block) error {
+	bucket := tx.Bucket([]byte(addrIndexBucket))
+
+	for pos, transaction := range b.Transactions {
+		flows, err := addressFlows(tx, b, transaction)
+		if err != nil {
+			return err
+		}
+
+		for pubKeyHash, flow := range flows {
+			value := make([]byte, 0, len(transaction.ID)+16)
+			value = append(value, transaction.ID...)
+			value = binary.BigEndian.AppendUint64(value, uint64(flow[0]))
+			value = binary.BigEndian.AppendUint64(value, uint64(flow[1]))
+
+			err := bucket.Put(addrIndexKey([]byte(pubKeyHash), b.Height, pos), value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (addrIndex) disconnect(tx StoreTx, b *block) error {
+	bucket := tx.Bucket([]byte(addrIndexBucket))
+
+	for pos, transaction := range b.Transactions {
+		flows, err := addressFlows(tx, b, transaction)
+		if err != nil {
+			return err
+		}
+
+		for pubKeyHash := range flows {
+			err := bucket.Delete(addrIndexKey([]byte(pubKeyHash), b.Height, pos))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addrIndexKey(pubKeyHash []byte, height int, pos int) []byte {
+	key := append([]byte{}, pubKeyHash...)
+	key = binary.BigEndian.AppendUint32(key, uint32(height))
+	return binary.BigEndian.AppendUint32(key, uint32(pos))
+}
+
+// addressFlows returns the amounts received and sent by every hash transaction of block b touches
+func addressFlows(tx StoreTx, b *block, transaction *Transaction) (map[string][2]int, error) {
+	flows := make(map[string][2]int)
+
+	for _, out := range transaction.Vout {
+		if out.isUnspendable() {
+			continue
+		}
+		flow := flows[string(out.PubKeyHash)]
+		flow[0] += out.Value
+		flows[string(out.PubKeyHash)] = flow
+	}
+
+	if transaction.isCoinbase() {
+		return flows, nil
+	}
+
+	for _, vin := range transaction.Vin {
+		prevTX := findTransactionInTx(tx, vin.Txid, b.PrevBlockHash, b.Transactions)
+		if prevTX == nil || vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			return nil, fmt.Errorf("output %x:%d spent in block %x is not found", vin.Txid, vin.Vout, b.Hash)
+		}
+
+		prevOut := prevTX.Vout[vin.Vout]
+		flow := flows[string(prevOut.PubKeyHash)]
+		flow[1] += prevOut.Value
+		flows[string(prevOut.PubKeyHash)] = flow
+	}
+
+	return flows, nil
+}
+
+// findTransactionInTx finds transaction ID among pending, then with the
+// transaction index if it has it and otherwise by walking back from the
+// block fromHash. It runs inside a bolt transaction, so it can be used while
+// an index is updated
+func findTransactionInTx(tx StoreTx, ID []byte, fromHash []byte, pending []*Transaction) *Transaction {
+	for _, transaction := range pending {
+		if bytes.Equal(transaction.ID, ID) {
+			return transaction
+		}
+	}
+
+	if bucket := tx.Bucket([]byte(txIndexBucket)); bucket != nil {
+		if value := bucket.Get(ID); value != nil {
+			b := blockInTx(tx, value[:len(value)-4])
+			if b != nil {
+				pos := int(binary.BigEndian.Uint32(value[len(value)-4:]))
+				if pos < len(b.Transactions) && bytes.Equal(b.Transactions[pos].ID, ID) {
+					return b.Transactions[pos]
+				}
+			}
+		}
+	}
+
+	for hash := fromHash; len(hash) > 0; {
+		b := blockInTx(tx, hash)
+		if b == nil {
+			return nil
+		}
+		for _, transaction := range b.Transactions {
+			if bytes.Equal(transaction.ID, ID) {
+				return transaction
+			}
+		}
+		hash = b.PrevBlockHash
+	}
+
+	return nil
+}
+
+// addressHistory returns the indexed transactions of pubKeyHash in chain order
+func (bc *blockchain) addressHistory(pubKeyHash []byte) ([]addrHistoryEntry, error) {
+	var history []addrHistoryEntry
+
+	err := bc.db.View(func(tx StoreTx) error {
+		if !bc.indexSynced(tx, addrIndex{}) {
+			return errors.New("the address index is not enabled or still being built")
+		}
+
+		cursor := tx.Bucket([]byte(addrIndexBucket)).Cursor()
+		for k, v := cursor.Seek(pubKeyHash); k != nil && bytes.HasPrefix(k, pubKeyHash); k, v = cursor.Next() {
+			if len(k) != len(pubKeyHash)+8 {
+				continue // a longer hash sharing the prefix
+			}
+			idLen := len(v) - 16
+			history = append(history, addrHistoryEntry{
+				TxID:     append([]byte{}, v[:idLen]...),
+				Height:   int(binary.BigEndian.Uint32(k[len(pubKeyHash):])),
+				Received: int(binary.BigEndian.Uint64(v[idLen:])),
+				Sent:     int(binary.BigEndian.Uint64(v[idLen+8:])),
+			})
+		}
+
+		return nil
+	})
+
+	return history, err
+}
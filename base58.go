@@ -23,7 +23,7 @@ func base58Encode(input []byte) []byte { // encode a byte slice to base58
 		result[i], result[j] = result[j], result[i]
 	}
 
-	for b := range input { // for each byte in the input
+	for _, b := range input { // for each byte in the input
 		if b == 0x00 { // if the byte is 0
 			result = append([]byte{b58Alphabet[0]}, result...) // append the corresponding character to the result
 		} else {
@@ -7,6 +7,10 @@ import (
 	"time"
 )
 
+const blockVersion = 2 // version 2 blocks commit to the witness hashes of their transactions, their version and height
+
+const versionHeightKey = "versionheight" // meta key of the height from which blocks must have blockVersion
+
 type block struct {
 	Timestamp     int64
 	Transactions  []*Transaction
@@ -14,10 +18,13 @@ type block struct {
 	Hash          []byte
 	Nonce         int
 	Height        int		// add a height field to the block, representing the block's position in the blockchain
+	Version       int
+	Pruned        bool   // the transactions were dropped by pruning, leaving the header
+	Commitments   []byte // Merkle roots of the transactions of a pruned block
 }
 
-func newBlock(transactions []*Transaction, prevBlockHash []byte, height int) *block {
-	block := &block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height}
+func newBlock(timestamp int64, transactions []*Transaction, prevBlockHash []byte, height int) *block {
+	block := &block{timestamp, transactions, prevBlockHash, []byte{}, 0, height, blockVersion, false, nil}
 	pow := newPow(block)
 	nonce, hash := pow.run()
 
@@ -45,8 +52,19 @@ func newBlock(transactions []*Transaction, prevBlockHash []byte, height int) *bl
 
 // }
 
+// pruned returns the header of b with its transactions dropped, keeping
+// their Merkle roots so the proof of work still validates
+func (b *block) pruned() *block {
+	header := *b
+	header.Commitments = newPow(b).commitments
+	header.Transactions = nil
+	header.Pruned = true
+
+	return &header
+}
+
 func genesisBlock(coinbase *Transaction) *block {
-	return newBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return newBlock(time.Now().Unix(), []*Transaction{coinbase}, []byte{}, 0)
 }
 
 func (b *block) serialize() []byte {
@@ -62,24 +80,56 @@ func (b *block) serialize() []byte {
 }
 
 func deserialize(data []byte) *block {
+	block, err := decodeBlock(data) // decode the data
+	if err != nil {                 // check for errors
+		log.Panic(err)
+	}
+
+	return block // return the deserialized block
+}
+
+// decodeBlock is deserialize for data that may be corrupt, from a file or a peer
+func decodeBlock(data []byte) (*block, error) {
 	var block block                                  // create a new block
 	decoder := gob.NewDecoder(bytes.NewReader(data)) // create a new decoder
 
-	err := decoder.Decode(&block) // decode the data
-	if err != nil {               // check for errors
-		log.Panic(err)
+	err := decoder.Decode(&block)
+	if err != nil {
+		return nil, err
 	}
 
-	return &block // return the deserialized block
+	return &block, nil
 }
 
 func (b *block) hashTransactions() []byte {
+	if b.Version == 0 {
+		return nil // the Merkle tree of version 0 blocks never hashed its nodes, so their root is empty
+	}
+
+	mTree := newMerkleTree(b.transactionIDs()) // create a new Merkle tree
+	return mTree.RootNode.Data                // return the root node data
+}
+
+// hashWitnesses returns the Merkle root of the witness hashes or nil before version 2
+func (b *block) hashWitnesses() []byte {
+	if b.Version < 2 {
+		return nil
+	}
+
+	var hashes [][]byte
+	for _, tx := range b.Transactions {
+		hashes = append(hashes, tx.witnessHash())
+	}
+
+	return newMerkleTree(hashes).RootNode.Data
+}
+
+func (b *block) transactionIDs() [][]byte {
 	var transactions [][]byte // create a new slice of byte slices
 
 	for _, tx := range b.Transactions { // iterate over the transactions
 		transactions = append(transactions, tx.ID) // append the transaction ID to the slice
 	}
 
-	mTree := newMerkleTree(transactions) // create a new Merkle tree
-	return mTree.RootNode.Data          // return the root node data
+	return transactions
 }
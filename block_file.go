@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const blockFileMagic = "blks"
+const blockFileVersion = 1
+
+// A block file holds, after a magic, a version and the chain name, the
+// serialized blocks in height order, each one a chunk as in UTXO snapshots
+
+// exportBlocks writes the blocks of the active chain from height from to
+// height to, both included, to w and returns how many it wrote
+func (bc *blockchain) exportBlocks(w io.Writer, from, to int) (int, error) {
+	_, err := w.Write([]byte(blockFileMagic))
+	if err != nil {
+		return 0, err
+	}
+	err = binary.Write(w, binary.BigEndian, uint32(blockFileVersion))
+	if err != nil {
+		return 0, err
+	}
+	err = writeChunk(w, []byte(activeChain.Name))
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for _, hash := range bc.getBlockHashesInRange(from, to) {
+		b, err := bc.getBlock(hash)
+		if err != nil {
+			return written, err
+		}
+		if b.Pruned {
+			return written, fmt.Errorf("block %x at height %d is pruned", b.Hash, b.Height)
+		}
+
+		err = writeChunk(w, b.serialize())
+		if err != nil {
+			return written, err
+		}
+		written++
+	}
+
+	return written, nil
+}
+
+// blockFileReader reads the blocks of a block file one at a time
+type blockFileReader struct {
+	r io.Reader
+}
+
+func newBlockFileReader(r io.Reader) (*blockFileReader, error) {
+	magic := make([]byte, len(blockFileMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != blockFileMagic {
+		return nil, errors.New("not a block file")
+	}
+
+	var version uint32
+	err = binary.Read(r, binary.BigEndian, &version)
+	if err != nil {
+		return nil, err
+	}
+	if version != blockFileVersion {
+		return nil, fmt.Errorf("block file version %d is not supported", version)
+	}
+
+	chain, err := readChunk(r)
+	if err != nil {
+		return nil, err
+	}
+	if string(chain) != activeChain.Name {
+		return nil, fmt.Errorf("the blocks are of the %s chain", chain)
+	}
+
+	return &blockFileReader{r}, nil
+}
+
+// next returns the next block of the file, or io.EOF after the last one
+func (f *blockFileReader) next() (*block, error) {
+	data, err := readChunk(f.r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("the file is truncated: %s", err)
+	}
+
+	return decodeBlock(data)
+}
+
+// checkGenesis checks that b can start a chain
+func checkGenesis(b *block) error {
+	if b.Height != 0 || len(b.PrevBlockHash) != 0 {
+		return fmt.Errorf("block %x is not a genesis block", b.Hash)
+	}
+	if b.Pruned || len(b.Transactions) != 1 || !b.Transactions[0].isCoinbase() {
+		return fmt.Errorf("genesis block %x must hold just a coinbase", b.Hash)
+	}
+	if !newPow(b).validateHash() {
+		return fmt.Errorf("genesis block %x has no valid proof of work", b.Hash)
+	}
+
+	return nil
+}
+
+// importBlock validates b and connects it on top of the tip, with the tip
+// and the indexes in one transaction. It reports false for a block that
+// is stored already
+func (bc *blockchain) importBlock(b *block) (bool, error) {
+	if _, err := bc.getBlock(b.Hash); err == nil {
+		return false, nil
+	}
+
+	if b.Pruned || len(b.Transactions) == 0 {
+		return false, fmt.Errorf("block %x has no transactions", b.Hash)
+	}
+	if err := checkDuplicateTransactions(b.Transactions); err != nil {
+		return false, fmt.Errorf("block %x: %w", b.Hash, err)
+	}
+	if !newPow(b).validateHash() {
+		return false, fmt.Errorf("block %x has no valid proof of work", b.Hash)
+	}
+	if !bytes.Equal(b.PrevBlockHash, bc.tip) {
+		return false, fmt.Errorf("block %x at height %d doesn't extend the tip %x", b.Hash, b.Height, bc.tip)
+	}
+	if height := bc.getBestHeight() + 1; b.Height != height {
+		return false, fmt.Errorf("block %x has height %d instead of %d", b.Hash, b.Height, height)
+	}
+
+	// connecting the block to the UTXO set validates it
+	err := bc.db.Update(func(tx StoreTx) error {
+		err := putBlock(tx, b)
+		if err != nil {
+			return err
+		}
+
+		err = setTip(tx, b.Hash)
+		if err != nil {
+			return err
+		}
+
+		err = updateIndexes(tx, bc.tip, b.Hash)
+		if err != nil {
+			return err
+		}
+		if !bc.indexSynced(tx, chainstate{}) {
+			return fmt.Errorf("block %x: the UTXO set can't be moved to the block to validate it", b.Hash)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	bc.tip = b.Hash
+
+	return true, nil
+}
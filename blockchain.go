@@ -2,84 +2,113 @@ package main
 
 import (
 	"bytes"
-	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/boltdb/bolt" // import the bolt package
 	"log"
 	"os"
+	"time"
 )
 
-const dbFile = "blockchain_%s.db" // name of the database file
 const blocksBucket = "blocks"  // name of the bucket
-const genesisCoinbaseData = "03/04/2011 First Hosts To Win Cup, With Highest-Ever Runchase In Final"
+
+var errBlocksPruned = errors.New("Not found in the blocks kept, the older ones are pruned")
 
 type blockchain struct {
 	tip []byte   // hash of the last block
-	db  *bolt.DB // pointer to the database
+	db  ChainStore // storage of the blocks, UTXO set and indexes
 }
 
-func (bc *blockchain) mineBlock(transactions []*Transaction) *block { // mine a new block
+// mineBlock mines transactions into a block on top of the tip. An invalid
+// transaction is reported before the proof of work is done. The block is
+// timestamped now, or right after the median time past if the clock is behind
+func (bc *blockchain) mineBlock(transactions []*Transaction) (*block, error) {
 	var lastHash []byte
 	var lastHeight int
+	timestamp := time.Now().Unix()
 
-	for _, tx := range transactions {
-		//ignore transactions that are not valid
-		if bc.verifyTransaction(tx) == false {
-			log.Panic("ERROR: Invalid transaction")
-		}
-	}
+	err := bc.db.View(func(tx StoreTx) error { // read the last block hash from the database
+		lastHash = tipInTx(tx)
+		lastBlock := blockInTx(tx, lastHash)
+		lastHeight = lastBlock.Height
 
-	err := bc.db.View(func(tx *bolt.Tx) error { // read the last block hash from the database
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
-
-		blockData := b.Get(lastHash)
-		block := deserialize(blockData)
-		lastHeight = block.Height
+		if medianTime := medianTimePast(tx, lastBlock); timestamp <= medianTime {
+			timestamp = medianTime + 1
+		}
 
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+
+	err = bc.validateBlockTransactions(transactions, lastHeight+1, timestamp)
+	if err != nil {
+		return nil, err
 	}
 
-	newBlock := newBlock(transactions, lastHash, lastHeight + 1) // create a new block
+	newBlock := newBlock(timestamp, transactions, lastHash, lastHeight + 1) // create a new block
 
-	err = bc.db.Update(func(tx *bolt.Tx) error { // write the new block to the database
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.serialize())
-		if err != nil {
-			log.Panic(err)
+	err = bc.db.Update(func(tx StoreTx) error { // write the new block to the database
+		if !bytes.Equal(tipInTx(tx), lastHash) {
+			return errors.New("the tip changed while mining")
 		}
 
-		err = b.Put([]byte("l"), newBlock.Hash)
+		err := putBlock(tx, newBlock)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		bc.tip = newBlock.Hash
+		err = setTip(tx, newBlock.Hash)
+		if err != nil {
+			return err
+		}
 
-		return nil
+		return updateIndexes(tx, lastHash, newBlock.Hash)
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
+	bc.tip = newBlock.Hash
 
-	return newBlock
-
+	return newBlock, nil
 }
 
 func (bc *blockchain) findTransaction(ID []byte) (Transaction, error) { // find a transaction by its ID
+	block, err := bc.findTransactionBlock(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	for _, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, ID) {
+			return *tx, nil
+		}
+	}
+
+	return Transaction{}, errors.New("Transaction is not found")
+}
+
+func (bc *blockchain) findTransactionBlock(ID []byte) (*block, error) { // find the block containing a transaction
+	blockHash, _, err := bc.lookupTxIndex(ID)
+	if err == nil {
+		return bc.getBlock(blockHash)
+	}
+	if err != errTxIndexUnavailable {
+		return nil, err
+	}
+
 	bci := bc.iterator()
 
 	for {
 		block := bci.next()
+		if block.Pruned {
+			return nil, errBlocksPruned
+		}
 
 		for _, tx := range block.Transactions {
 			if bytes.Equal(tx.ID, ID) {
-				return *tx, nil
+				return block, nil
 			}
 		}
 
@@ -88,39 +117,60 @@ func (bc *blockchain) findTransaction(ID []byte) (Transaction, error) { // find
 		}
 	}
 
-	return Transaction{}, errors.New("Transaction is not found")
+	return nil, errors.New("Transaction is not found")
 }
 
-func (bc *blockchain) findUTXO() map[string]TXOutputs {
-	UTXO := make(map[string]TXOutputs)
-	spentTXOs := make(map[string][]int)
+// findSpendingTransaction returns the transaction of the active chain spending txid:vout
+func (bc *blockchain) findSpendingTransaction(txid []byte, vout int) (*Transaction, int, error) {
 	bci := bc.iterator()
 
 	for {
 		block := bci.next()
+		if block.Pruned {
+			return nil, -1, errBlocksPruned
+		}
 
 		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
+			if tx.isCoinbase() {
+				continue
+			}
+			for inID, vin := range tx.Vin {
+				if bytes.Equal(vin.Txid, txid) && vin.Vout == vout {
+					return tx, inID, nil
+				}
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil, -1, errors.New("Output is not spent")
+}
+
+// findUTXO walks the chain for its unspent outputs, keyed by outpoint key
+func (bc *blockchain) findUTXO() map[string]utxoEntry {
+	UTXO := make(map[string]utxoEntry)
+	spentTXOs := make(map[string]bool)
+	bci := bc.iterator()
 
-		Outputs:
+	for {
+		block := bci.next()
+
+		for _, tx := range block.Transactions {
 			for outIdx, out := range tx.Vout {
-				if spentTXOs[txID] != nil {
-					for _, spentOut := range spentTXOs[txID] {
-						if spentOut == outIdx {
-							continue Outputs
-						}
-					}
+				key := string(outpoint{tx.ID, outIdx}.key())
+				if out.isUnspendable() || spentTXOs[key] {
+					continue
 				}
 
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
+				UTXO[key] = utxoEntry{out, block.Height, tx.isCoinbase()}
 			}
 
 			if !tx.isCoinbase() {
 				for _, in := range tx.Vin {
-					inTXID := hex.EncodeToString(in.Txid)
-					spentTXOs[inTXID] = append(spentTXOs[inTXID], in.Vout)
+					spentTXOs[string(outpoint{in.Txid, in.Vout}.key())] = true
 				}
 			}
 		}
@@ -148,118 +198,193 @@ func dbExists(dbFile string) bool { // check if the database exists
 }
 
 func newBlockchain(nodeID string) *blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	dbFile := fmt.Sprintf(activeChain.DBFile, nodeID)
 
 	if dbExists(dbFile) == false { // check if the database exists
 		fmt.Println("No existing blockchain found. Create one!")
 		os.Exit(1)
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil) // open the database
-	if err != nil {                         // check for errors
+	store, err := openBoltStore(dbFile) // open the database
+	if err != nil {                     // check for errors
 		log.Panic(err)
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error { // write the genesis block to the database
-		b := tx.Bucket([]byte(blocksBucket)) // get the bucket
-		tip = b.Get([]byte("l"))             // get the last block hash
+	return openBlockchain(newUTXOCacheStore(store, utxoCacheBudget))
+}
+
+// openBlockchain loads the blockchain kept in store
+func openBlockchain(store ChainStore) *blockchain {
+	var tip []byte
+
+	err := store.View(func(tx StoreTx) error {
+		tip = tipInTx(tx) // get the last block hash
 
 		return nil
 	})
-
 	if err != nil { // check for errors
 		log.Panic(err)
 	}
 
-	bc := blockchain{tip, db} // create a new blockchain
+	bc := blockchain{tip, store} // create a new blockchain
+	bc.upgradeSchema()
+	bc.checkChainstate()
+
 	return &bc
 }
 
 func createBlockchain(address string, nodeID string) *blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	return initBlockchain(createStore(nodeID), address)
+}
+
+// createBlockchainFromGenesis creates the blockchain of nodeID with a genesis block mined elsewhere
+func createBlockchainFromGenesis(genesis *block, nodeID string) *blockchain {
+	return initBlockchainWithGenesis(createStore(nodeID), genesis)
+}
+
+// createStore creates the database of nodeID, which must not exist yet
+func createStore(nodeID string) ChainStore {
+	dbFile := fmt.Sprintf(activeChain.DBFile, nodeID)
 	
 	if dbExists(dbFile) {
 		fmt.Println("Blockchain already exists.")
 		os.Exit(1)
 	}
 
-	var tip []byte
+	store, err := openBoltStore(dbFile) // open the database
+	if err != nil {                     // check for errors
+		log.Panic(err)
+	}
+
+	return newUTXOCacheStore(store, utxoCacheBudget)
+}
 
-	cbtx := newCoinbaseTX(address, genesisCoinbaseData) // create a coinbase transaction
+// initBlockchain writes a genesis block paying address to an empty store.
+// With newMemoryStore() it gives a chain that never touches the filesystem
+func initBlockchain(store ChainStore, address string) *blockchain {
+	cbtx := newCoinbaseTX(address, activeChain.GenesisCoinbaseData, 0) // create a coinbase transaction
 	genesis := genesisBlock(cbtx)                       // create a genesis block
 
-	db, err := bolt.Open(dbFile, 0600, nil) // open the database
-	if err != nil {                         // check for errors
-		log.Panic(err)
-	}
+	return initBlockchainWithGenesis(store, genesis)
+}
 
-	err = db.Update(func(tx *bolt.Tx) error { // write the genesis block to the database
-		b, err := tx.CreateBucket([]byte(blocksBucket)) // create a new bucket
+// initBlockchainWithGenesis writes genesis to an empty store
+func initBlockchainWithGenesis(store ChainStore, genesis *block) *blockchain {
+	err := store.Update(func(tx StoreTx) error { // write the genesis block to the database
+		_, err := tx.CreateBucket([]byte(blocksBucket)) // create a new bucket
 		if err != nil {                                 // check for errors
 			log.Panic(err)
 		}
-		err = b.Put(genesis.Hash, genesis.serialize()) // write the genesis block to the bucket
-		if err != nil {                                // check for errors
+		err = putBlock(tx, genesis) // write the genesis block to the bucket
+		if err != nil {             // check for errors
 			log.Panic(err)
 		}
-		err = b.Put([]byte("l"), genesis.Hash) // update the last block hash
-		if err != nil {                        // check for errors
+		err = setTip(tx, genesis.Hash) // update the last block hash
+		if err != nil {                // check for errors
 			log.Panic(err)
 		}
-		tip = genesis.Hash // update the tip of the blockchain
+
+		err = writeSchemaVersion(tx, schemaVersion)
+		if err != nil {
+			return err
+		}
+
+		for _, index := range []chainIndex{chainstate{}, heightIndex{}} { // the always enabled indexes start at the genesis block
+			_, err = tx.CreateBucket([]byte(index.name()))
+			if err != nil {
+				return err
+			}
+
+			err = index.connect(tx, genesis)
+			if err != nil {
+				return err
+			}
+
+			err = setIndexTip(tx, index, genesis.Hash)
+			if err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := blockchain{tip, db} // create a new blockchain
+	bc := blockchain{genesis.Hash, store} // create a new blockchain
 
 	return &bc
 }
 
-func (bc *blockchain) addBlock(block *block) { // add a block to the blockchain
-	err := bc.db.Update(func(tx *bolt.Tx) error { // write the block to the database
-		b := tx.Bucket([]byte(blocksBucket))
-		blockData := b.Get(block.Hash)
+// addBlock stores a block received from a peer and makes it the tip if it
+// extends the longest chain, connecting it to the UTXO set, which validates
+// its transactions. It returns the error that kept the block out
+func (bc *blockchain) addBlock(block *block) error {
+	var tip []byte
+
+	err := bc.db.Update(func(tx StoreTx) error { // write the block to the database
+		if stored := blockInTx(tx, block.Hash); stored != nil {
+			_, pending := snapshotBaseInTx(tx)
+			if pending && stored.Pruned && !block.Pruned && bytes.Equal(newPow(block).commitments, stored.Commitments) {
+				err := checkDuplicateTransactions(block.Transactions)
+				if err != nil {
+					return err
+				}
+
+				return putBlock(tx, block) // a block below a loaded UTXO snapshot, for checkSnapshot
+			}
 
-		if blockData != nil {
 			return nil
 		}
 
-		err := b.Put(block.Hash, block.serialize())
+		err := checkBlock(tx, block)
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+
+		err = putBlock(tx, block)
+		if err != nil {
+			return err
 		}
 
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := deserialize(lastBlockData)
+		lastHash := tipInTx(tx)
+		lastBlock := blockInTx(tx, lastHash)
 
 		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
+			err = setTip(tx, block.Hash)
+			if err != nil {
+				return err
+			}
+
+			err = updateIndexes(tx, lastHash, block.Hash)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
-			bc.tip = block.Hash
+			if !bc.indexSynced(tx, chainstate{}) {
+				return errors.New("the UTXO set can't be moved to the block to validate it")
+			}
+			tip = block.Hash
 		}
 
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+
+	if tip != nil {
+		bc.tip = tip
+	}
+
+	return nil
 }
 
 func (bc *blockchain) getBestHeight() int { // get the height of the last block
 	var lastBlock *block
 
-	err := bc.db.View(func(tx *bolt.Tx) error { // read the last block from the database
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash := b.Get([]byte("l"))
-		blockData := b.Get(lastHash)
-		lastBlock = deserialize(blockData)
+	err := bc.db.View(func(tx StoreTx) error { // read the last block from the database
+		lastBlock = blockInTx(tx, tipInTx(tx))
 
 		return nil
 	})
@@ -273,16 +398,12 @@ func (bc *blockchain) getBestHeight() int { // get the height of the last block
 func (bc *blockchain) getBlock(hash []byte) (*block, error) { // get a block by its hash
 	var block *block
 
-	err := bc.db.View(func(tx *bolt.Tx) error { // read the block from the database
-		b := tx.Bucket([]byte(blocksBucket))
-		blockData := b.Get(hash)
-
-		if blockData == nil {
+	err := bc.db.View(func(tx StoreTx) error { // read the block from the database
+		block = blockInTx(tx, hash)
+		if block == nil {
 			return errors.New("Block is not found")
 		}
 
-		block = deserialize(blockData)
-
 		return nil
 	})
 	if err != nil {
@@ -292,36 +413,36 @@ func (bc *blockchain) getBlock(hash []byte) (*block, error) { // get a block by
 	return block, nil
 }
 
-func (bc *blockchain) getBlockHashes() [][]byte { // get the hashes of all blocks in the blockchain
-	var blocks [][]byte
-
-	bci := bc.iterator()
+func (bc *blockchain) getBlockAtHeight(height int) (*block, error) { // get a block of the active chain by its height
+	hash, err := bc.getBlockHashAtHeight(height)
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		block := bci.next()
+	return bc.getBlock(hash)
+}
 
-		blocks = append(blocks, block.Hash)
+func (bc *blockchain) getBlockHashes() [][]byte { // get the hashes of all blocks in the blockchain, newest first
+	hashes := bc.getBlockHashesInRange(0, bc.getBestHeight())
 
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
 	}
 
-	return blocks
+	return hashes
 }
 
-func (bc *blockchain) signTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
+func (bc *blockchain) signTransaction(tx *Transaction, wallet *Wallet, hashType sigHashType) {
+	prevTXs := bc.prevTransactions(tx)
 
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.findTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
-	}
+	tx.sign(wallet, prevTXs, hashType)
+}
 
-	tx.sign(privKey, prevTXs)
+// signMultisigTransaction adds the signature of wallet to every multisig input it is a signer of
+func (bc *blockchain) signMultisigTransaction(tx *Transaction, wallet *Wallet, hashType sigHashType) int {
+	prevTXs := bc.prevTransactions(tx)
+
+	return tx.signMultisig(wallet, prevTXs, hashType)
 }
 
 func (bc *blockchain) verifyTransaction(tx *Transaction) bool {
@@ -329,15 +450,69 @@ func (bc *blockchain) verifyTransaction(tx *Transaction) bool {
 		return true
 	}
 
+	prevTXs, err := bc.lookupPrevTransactions(tx)
+	if err != nil {
+		return false
+	}
+
+	return tx.verify(prevTXs)
+}
+
+// transactionFee returns the value of the inputs of tx not claimed by its
+// outputs, negative for a transaction not funded yet
+func (bc *blockchain) transactionFee(tx *Transaction) (int, error) {
+	if tx.isCoinbase() {
+		return 0, nil
+	}
+
+	prevTXs, err := bc.lookupPrevTransactions(tx)
+	if err != nil {
+		return 0, reject("missing-inputs", "%s", err)
+	}
+	fee := 0
+
+	for i, vin := range tx.Vin {
+		prevTX := prevTXs[hex.EncodeToString(vin.Txid)]
+		if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			return 0, reject("bad-txns-inputs-missing", "input %d spends a missing output %x:%d", i, vin.Txid, vin.Vout)
+		}
+		fee += prevTX.Vout[vin.Vout].Value
+	}
+
+	for _, out := range tx.Vout {
+		fee -= out.Value
+	}
+
+	return fee, nil
+}
+
+// prevTransactions collects the transactions whose outputs are spent by tx
+func (bc *blockchain) prevTransactions(tx *Transaction) map[string]Transaction {
+	prevTXs, err := bc.lookupPrevTransactions(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return prevTXs
+}
+
+func (bc *blockchain) lookupPrevTransactions(tx *Transaction) (map[string]Transaction, error) {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
 		prevTX, err := bc.findTransaction(vin.Txid)
+		if err == errBlocksPruned {
+			var found bool
+			prevTX, found = UTXOSet{bc}.unspentTransaction(vin.Txid)
+			if found {
+				err = nil
+			}
+		}
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	return tx.verify(prevTXs)
+	return prevTXs, nil
 }
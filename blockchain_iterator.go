@@ -2,21 +2,18 @@ package main
 
 import(
 	"log"
-	"github.com/boltdb/bolt"
 )
 
 type blockchainIterator struct{
 	currentHash []byte
-	db *bolt.DB
+	db ChainStore
 }
 
 func (i *blockchainIterator) next() *block{
 	var block *block
 
-	err := i.db.View(func(tx *bolt.Tx) error{
-		b := tx.Bucket([]byte(blocksBucket))
-		encodedBlock := b.Get(i.currentHash)
-		block = deserialize(encodedBlock)
+	err := i.db.View(func(tx StoreTx) error{
+		block = blockInTx(tx, i.currentHash)
 
 		return nil
 	})
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const indexStateBucket = "indexstate" // index name -> hash of the tip the index is in step with
+const indexBuildBatch = 500           // blocks connected per write transaction when building an index
+
+// chainIndex is a structure kept in step with the active chain, written in
+// the same transaction as the tip. Each index owns the bucket named after
+// it; it exists only once the index is enabled. The UTXO set and the
+// height index are always enabled, the others are optional lookups
+type chainIndex interface {
+	name() string
+	connect(tx StoreTx, b *block) error
+	disconnect(tx StoreTx, b *block) error
+}
+
+var chainIndexes = []chainIndex{chainstate{}, heightIndex{}, txIndex{}, addrIndex{}}
+
+// indexTip returns the tip an enabled index is in step with, nil if it is
+// still being built, and false if the index isn't enabled
+func indexTip(tx StoreTx, index chainIndex) ([]byte, bool) {
+	if tx.Bucket([]byte(index.name())) == nil {
+		return nil, false
+	}
+
+	state := tx.Bucket([]byte(indexStateBucket))
+	if state == nil {
+		return nil, true
+	}
+
+	return state.Get([]byte(index.name())), true
+}
+
+func setIndexTip(tx StoreTx, index chainIndex, tip []byte) error {
+	state, err := tx.CreateBucketIfNotExists([]byte(indexStateBucket))
+	if err != nil {
+		return err
+	}
+
+	return state.Put([]byte(index.name()), tip)
+}
+
+// indexSynced reports whether index is enabled and in step with the chain tip
+func (bc *blockchain) indexSynced(tx StoreTx, index chainIndex) bool {
+	tip, enabled := indexTip(tx, index)
+
+	return enabled && tip != nil && bytes.Equal(tip, tipInTx(tx))
+}
+
+// findFork returns the blocks to disconnect, newest first, and to connect,
+// oldest first, to move from oldTip to newTip. It fails if a block between
+// them isn't stored yet, as during a sync receiving blocks out of order
+func findFork(tx StoreTx, oldTip, newTip []byte) ([]*block, []*block, bool) {
+	var disconnect, connect []*block
+
+	oldBlock := blockInTx(tx, oldTip)
+	newBlock := blockInTx(tx, newTip)
+
+	for oldBlock != nil && newBlock != nil && !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		if oldBlock.Height >= newBlock.Height {
+			disconnect = append(disconnect, oldBlock)
+			oldBlock = blockInTx(tx, oldBlock.PrevBlockHash)
+		} else {
+			connect = append([]*block{newBlock}, connect...)
+			newBlock = blockInTx(tx, newBlock.PrevBlockHash)
+		}
+	}
+
+	if oldBlock == nil || newBlock == nil {
+		return nil, nil, false
+	}
+
+	return disconnect, connect, true
+}
+
+// updateIndexes moves every enabled index that was in step with oldTip to
+// newTip. Indexes that can't follow are left behind and caught up by syncIndexes
+func updateIndexes(tx StoreTx, oldTip, newTip []byte) error {
+	for _, index := range chainIndexes {
+		tip, enabled := indexTip(tx, index)
+		if !enabled || !bytes.Equal(tip, oldTip) {
+			continue
+		}
+
+		_, err := moveIndex(tx, index, oldTip, newTip)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// undoChecker is implemented by indexes that can only disconnect the
+// blocks they recorded undo data for
+type undoChecker interface {
+	canDisconnect(tx StoreTx, b *block) bool
+}
+
+// moveIndex disconnects and connects the blocks taking index from oldTip
+// to newTip. It reports false, without writing anything, when a block
+// between them isn't stored or can't be disconnected
+func moveIndex(tx StoreTx, index chainIndex, oldTip, newTip []byte) (bool, error) {
+	disconnect, connect, ok := findFork(tx, oldTip, newTip)
+	if !ok {
+		return false, nil
+	}
+
+	if checker, isChecker := index.(undoChecker); isChecker {
+		for _, b := range disconnect {
+			if !checker.canDisconnect(tx, b) {
+				return false, nil
+			}
+		}
+	}
+
+	for _, b := range disconnect {
+		err := index.disconnect(tx, b)
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, b := range connect {
+		err := index.connect(tx, b)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, setIndexTip(tx, index, newTip)
+}
+
+// enableIndex creates the bucket of index so it is built by syncIndexes and maintained from then on
+func (bc *blockchain) enableIndex(index chainIndex) {
+	err := bc.db.Update(func(tx StoreTx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(index.name()))
+		return err
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// disableIndex deletes the bucket and the tip of index, so it is no longer
+// maintained. It reports whether the index was enabled
+func (bc *blockchain) disableIndex(index chainIndex) bool {
+	enabled := false
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(index.name())) == nil {
+			return nil
+		}
+		enabled = true
+
+		err := tx.DeleteBucket([]byte(index.name()))
+		if err != nil {
+			return err
+		}
+
+		state := tx.Bucket([]byte(indexStateBucket))
+		if state == nil {
+			return nil
+		}
+
+		return state.Delete([]byte(index.name()))
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return enabled
+}
+
+// syncIndexes brings every enabled index in step with the chain tip. An
+// index whose tip isn't on the active chain is rebuilt from genesis; the
+// blocks are connected in batches so lookups and new blocks aren't held up
+func (bc *blockchain) syncIndexes() {
+	for _, index := range chainIndexes {
+		for {
+			connected, err := bc.syncIndex(index)
+			if err != nil {
+				fmt.Printf("Can't build the %s index: %s\n", index.name(), err)
+			}
+			if err != nil || connected == 0 {
+				break
+			}
+		}
+	}
+}
+
+// syncIndex connects the blocks between the tip of index and the chain tip and returns how many it connected
+func (bc *blockchain) syncIndex(index chainIndex) (int, error) {
+	var pending [][]byte // newest first
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		indexed, enabled := indexTip(tx, index)
+		if !enabled || bc.indexSynced(tx, index) {
+			return nil
+		}
+
+		hash := tipInTx(tx)
+		for len(hash) > 0 && !bytes.Equal(hash, indexed) {
+			b := blockInTx(tx, hash)
+			if b == nil {
+				return fmt.Errorf("block %x is missing", hash)
+			}
+			pending = append(pending, hash)
+			hash = b.PrevBlockHash
+		}
+
+		if len(hash) == 0 && indexed != nil {
+			// the indexed tip left the active chain, start over
+			if metaInt(tx, pruneHeightKey) > 0 {
+				return errors.New("its tip left the active chain and the pruned blocks can't rebuild it")
+			}
+			err := tx.DeleteBucket([]byte(index.name()))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket([]byte(index.name()))
+			if err != nil {
+				return err
+			}
+			return tx.Bucket([]byte(indexStateBucket)).Delete([]byte(index.name()))
+		}
+
+		return nil
+	})
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	fmt.Printf("Building the %s index for %d blocks\n", index.name(), len(pending))
+
+	connected := 0
+	for end := len(pending); end > 0; end -= indexBuildBatch {
+		start := end - indexBuildBatch
+		if start < 0 {
+			start = 0
+		}
+
+		err := bc.db.Update(func(tx StoreTx) error {
+			indexed, _ := indexTip(tx, index)
+			if !bytes.Equal(indexed, blockInTx(tx, pending[end-1]).PrevBlockHash) {
+				return errors.New("the chain changed while building")
+			}
+
+			for i := end - 1; i >= start; i-- {
+				err := index.connect(tx, blockInTx(tx, pending[i]))
+				if err != nil {
+					return err
+				}
+			}
+
+			return setIndexTip(tx, index, pending[start])
+		})
+		if err != nil {
+			return connected, err
+		}
+		connected += end - start
+	}
+
+	return connected, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import "os"
+
+// chainParams describes one independent instance of the chain. Running nodes
+// with different params (CHAIN env. var) keeps their databases and peers apart
+type chainParams struct {
+	Name                string
+	SeedNode            string // central node new nodes connect to
+	DBFile              string // database file name, formatted with the node ID
+	GenesisCoinbaseData string
+}
+
+var mainChainParams = chainParams{
+	Name:                "main",
+	SeedNode:            "localhost:3000",
+	DBFile:              "blockchain_%s.db",
+	GenesisCoinbaseData: "03/04/2011 First Hosts To Win Cup, With Highest-Ever Runchase In Final",
+}
+
+var altChainParams = chainParams{
+	Name:                "alt",
+	SeedNode:            "localhost:4000",
+	DBFile:              "blockchain_alt_%s.db",
+	GenesisCoinbaseData: "19/11/2023 Head Leads Australia To Sixth Title In Ahmedabad",
+}
+
+var chains = map[string]*chainParams{
+	mainChainParams.Name: &mainChainParams,
+	altChainParams.Name:  &altChainParams,
+}
+
+var activeChain = selectChainParams()
+
+// selectChainParams returns the params named by the CHAIN env. var, main by default
+func selectChainParams() *chainParams {
+	params, ok := chains[os.Getenv("CHAIN")]
+	if !ok {
+		return &mainChainParams
+	}
+
+	return params
+}
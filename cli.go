@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
@@ -11,14 +12,45 @@ type CLI struct{}
 
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
-	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
+	fmt.Println("  anchor -from FROM -data HEX | -file PATH [-fee FEE] -mine - Commit HEX or the SHA-256 hash of the file at PATH to the chain in an unspendable output")
+	fmt.Println("  auditswap -contract CONTRACT -txid TXID - Show the terms and state of a swap contract paid by TXID")
+	fmt.Println("  createblockchain -address ADDRESS [-txindex] [-addrindex] - Create a blockchain and send genesis block reward to ADDRESS. -txindex and -addrindex maintain the transaction and address indexes")
+	fmt.Println("  createmultisig -required M -pubkeys KEY,KEY,... - Create an M-of-N multisig address from hex public keys")
+	fmt.Println("  createmultisigtx -redeem SCRIPT -to TO -amount AMOUNT [-fee FEE] -out FILE - Write an unsigned transaction spending from a multisig address to FILE")
+	fmt.Println("  createpledge -to ADDRESS -goal AMOUNT -out FILE - Write a crowdfunding transaction paying AMOUNT to ADDRESS that contributors fund with pledge")
+	fmt.Println("  createwallet [-type ecdsa|ed25519] - Generates a new key-pair of the given type and saves it into the wallet file")
+	fmt.Println("  decoderawtx -in FILE - Print the transaction in FILE with its ID and witness hash")
+	fmt.Println("  dumptxoutset -out FILE [-height N] - Write a snapshot of the UTXO set at height N, the tip by default, with its hash")
+	fmt.Println("  exportblocks -out FILE [-from H] [-to H] - Write the blocks of the active chain from height H to height H, all of them by default, to FILE")
 	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("  getblock -height N | -hash HASH - Print a block of the active chain by height or any stored block by hash")
+	fmt.Println("  getblockhash -height N [-count C] - Print the hashes of C blocks of the active chain starting at height N")
+	fmt.Println("  gettransaction -txid TXID - Print a transaction of the chain with its block, position and confirmations")
+	fmt.Println("  getpubkey -address ADDRESS - Print the public key of a wallet address")
+	fmt.Println("  importblocks -in FILE - Validate the blocks in FILE and add them to the chain, creating it from their genesis block if there is none")
+	fmt.Println("  initiateswap -from FROM -to TO -amount AMOUNT -locktime N [-secrethash HASH] [-fee FEE] -mine - Lock AMOUNT in a swap contract TO can redeem with the secret and FROM can refund at N. Without -secrethash a new secret is generated")
+	fmt.Println("  history -address ADDRESS [-offset N] [-limit N] - List the transactions of ADDRESS, newest first, with the amount and running balance. Needs -addrindex")
 	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
+	fmt.Println("  listunspent -address ADDRESS - List the unspent outputs of ADDRESS with their value and confirmations")
+	fmt.Println("  loadtxoutset -in FILE - Create the blockchain from a UTXO snapshot whose hash is set in the ASSUMEUTXO=HEIGHT:HASH env. var. The node checks it against the older blocks in the background")
+	fmt.Println("  lockunspent -outputs TXID:VOUT,... - Reserve outputs so automatic coin selection skips them")
+	fmt.Println("  pledge -in FILE -from FROM -amount AMOUNT - Add inputs of FROM worth exactly AMOUNT to the crowdfunding transaction in FILE, signed ALL|ANYONECANPAY")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
+	fmt.Println("  redeemswap -contract CONTRACT -txid TXID -secret SECRET -mine - Redeem a swap contract by revealing the secret")
+	fmt.Println("  refundswap -contract CONTRACT -txid TXID -mine - Refund a swap contract after its lock time")
 	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
 	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. The -mine flag mines a block")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("       [-locktime N] [-relative BLOCKS|-relativetime SECONDS] [-out FILE] - Lock the transaction until height/timestamp N or BLOCKS/SECONDS after its inputs confirmed, -out writes it to FILE instead of sending")
+	fmt.Println("       [-fee FEE] [-strategy bnb|largest|smallest|privacy] [-dryrun] - Choose how inputs are selected, -dryrun prints the chosen inputs, fee and change")
+	fmt.Println("       [-inputs TXID:VOUT,...] - Spend exactly the listed outputs")
+	fmt.Println("       [-sighash ALL|NONE|SINGLE[|ANYONECANPAY]] - Parts of the transaction the input signatures commit to, defaults to ALL")
+	fmt.Println("  sendmany -from FROM -file FILE -fee FEE -mine [-strategy NAME] [-dryrun] - Pay every address,amount pair of a JSON or CSV FILE in one transaction")
+	fmt.Println("  sendrawtx -in FILE -miner ADDRESS - Broadcast a fully signed transaction from FILE. -miner mines it locally and sends the reward to ADDRESS")
+	fmt.Println("  signmultisigtx -in FILE -address ADDRESS [-sighash TYPE] - Add the signature of ADDRESS to the partially signed transaction in FILE")
+	fmt.Println("  unlockunspent -outputs TXID:VOUT,... - Release outputs reserved with lockunspent")
+	fmt.Println("  verifychain [-depth N] [-level L] - Check the last N blocks, 6 by default and all of them with 0, up to level L: 0 proof of work and hash linkage, 1 Merkle roots, 2 signatures, 3 (the default) the UTXO set rebuilt from the blocks against the stored one")
+	fmt.Println("  verifyanchor -data HEX | -file PATH -height HEIGHT - Prove that HEX or the hash of the file at PATH is committed in the block at HEIGHT")
+	fmt.Println("  startnode -miner ADDRESS [-txindex] [-addrindex] [-droptxindex] [-dropaddrindex] [-utxocache MB] [-prune N] - Start a node with ID specified in NODE_ID env. var. -miner enables mining, -txindex and -addrindex build the transaction and address indexes in the background, -droptxindex and -dropaddrindex delete them, -utxocache sets the memory for UTXO changes not yet written to disk, -prune keeps the transactions of only the last N blocks (at least 10), for good")
 }
 
 func (cli *CLI) validateArgs() {
@@ -37,37 +69,208 @@ func (cli *CLI) Run() {
 		os.Exit(1)
 	}
 
+	if chain := os.Getenv("CHAIN"); chain != "" && chains[chain] == nil {
+		fmt.Printf("Unknown chain %s in CHAIN env. var!\n", chain)
+		os.Exit(1)
+	}
+
+	anchorCmd := flag.NewFlagSet("anchor", flag.ExitOnError)
+	anchorFrom := anchorCmd.String("from", "", "Wallet address paying for the anchor transaction")
+	anchorData := anchorCmd.String("data", "", "Hex data to anchor")
+	anchorFile := anchorCmd.String("file", "", "File whose SHA-256 hash is anchored")
+	anchorFee := anchorCmd.Int("fee", 0, "Fee left to the miner")
+	anchorMine := anchorCmd.Bool("mine", false, "Mine immediately")
+
+	auditSwapCmd := flag.NewFlagSet("auditswap", flag.ExitOnError)
+	auditSwapContract := auditSwapCmd.String("contract", "", "Hex swap contract")
+	auditSwapTxid := auditSwapCmd.String("txid", "", "Transaction paying to the contract")
+
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send the genesis block reward to")
+	createBlockchainTxIndex := createBlockchainCmd.Bool("txindex", false, "Maintain the transaction index")
+	createBlockchainAddrIndex := createBlockchainCmd.Bool("addrindex", false, "Maintain the address index")
+
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createMultisigRequired := createMultisigCmd.Int("required", 0, "Number of signatures required to spend")
+	createMultisigPubKeys := createMultisigCmd.String("pubkeys", "", "Comma separated hex public keys of the signers")
+
+	createMultisigTxCmd := flag.NewFlagSet("createmultisigtx", flag.ExitOnError)
+	createMultisigTxRedeem := createMultisigTxCmd.String("redeem", "", "Hex redeem script of the multisig address")
+	createMultisigTxTo := createMultisigTxCmd.String("to", "", "Destination wallet address")
+	createMultisigTxAmount := createMultisigTxCmd.Int("amount", 0, "Amount to send")
+	createMultisigTxFee := createMultisigTxCmd.Int("fee", 0, "Fee left to the miner")
+	createMultisigTxOut := createMultisigTxCmd.String("out", "", "File to write the unsigned transaction to")
 
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	createWalletType := createWalletCmd.String("type", "ecdsa", "Key type: ecdsa or ed25519")
+
+	dumpTxOutSetCmd := flag.NewFlagSet("dumptxoutset", flag.ExitOnError)
+	dumpTxOutSetOut := dumpTxOutSetCmd.String("out", "", "File to write the snapshot to")
+	dumpTxOutSetHeight := dumpTxOutSetCmd.Int("height", -1, "Height of the UTXO set, the tip by default")
+
+	exportBlocksCmd := flag.NewFlagSet("exportblocks", flag.ExitOnError)
+	exportBlocksOut := exportBlocksCmd.String("out", "", "File to write the blocks to")
+	exportBlocksFrom := exportBlocksCmd.Int("from", 0, "Height of the first block")
+	exportBlocksTo := exportBlocksCmd.Int("to", -1, "Height of the last block, the tip by default")
+
+	importBlocksCmd := flag.NewFlagSet("importblocks", flag.ExitOnError)
+	importBlocksIn := importBlocksCmd.String("in", "", "File containing the blocks")
+
+	loadTxOutSetCmd := flag.NewFlagSet("loadtxoutset", flag.ExitOnError)
+	loadTxOutSetIn := loadTxOutSetCmd.String("in", "", "File containing the snapshot")
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	getBlockHeight := getBlockCmd.Int("height", -1, "Height of the block in the active chain")
+	getBlockHash := getBlockCmd.String("hash", "", "Hash of the block")
+
+	getBlockHashCmd := flag.NewFlagSet("getblockhash", flag.ExitOnError)
+	getBlockHashHeight := getBlockHashCmd.Int("height", -1, "Height of the first block")
+	getBlockHashCount := getBlockHashCmd.Int("count", 1, "Number of blocks")
+
+	getTransactionCmd := flag.NewFlagSet("gettransaction", flag.ExitOnError)
+	getTransactionTxid := getTransactionCmd.String("txid", "", "ID of the transaction")
+
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	historyAddress := historyCmd.String("address", "", "The address to list the transactions of")
+	historyOffset := historyCmd.Int("offset", 0, "Number of most recent transactions to skip")
+	historyLimit := historyCmd.Int("limit", 20, "Maximum number of transactions to list")
+
+	getPubKeyCmd := flag.NewFlagSet("getpubkey", flag.ExitOnError)
+	getPubKeyAddress := getPubKeyCmd.String("address", "", "The wallet address to print the public key of")
+
+	initiateSwapCmd := flag.NewFlagSet("initiateswap", flag.ExitOnError)
+	initiateSwapFrom := initiateSwapCmd.String("from", "", "Source wallet address, also the refund address")
+	initiateSwapTo := initiateSwapCmd.String("to", "", "Address that can redeem the contract")
+	initiateSwapAmount := initiateSwapCmd.Int("amount", 0, "Amount to lock in the contract")
+	initiateSwapFee := initiateSwapCmd.Int("fee", 0, "Fee left to the miner")
+	initiateSwapLockTime := initiateSwapCmd.Int64("locktime", 0, "Block height or unix timestamp after which FROM can refund")
+	initiateSwapSecretHash := initiateSwapCmd.String("secrethash", "", "Secret hash of the counterparty's contract")
+	initiateSwapMine := initiateSwapCmd.Bool("mine", false, "Mine immediately")
+
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	listUnspentAddress := listUnspentCmd.String("address", "", "The address to list unspent outputs for")
+
+	lockUnspentCmd := flag.NewFlagSet("lockunspent", flag.ExitOnError)
+	lockUnspentOutputs := lockUnspentCmd.String("outputs", "", "Comma separated txid:vout outputs to lock")
+
+	unlockUnspentCmd := flag.NewFlagSet("unlockunspent", flag.ExitOnError)
+	unlockUnspentOutputs := unlockUnspentCmd.String("outputs", "", "Comma separated txid:vout outputs to unlock")
+
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendLockTime := sendCmd.Int64("locktime", 0, "Block height or unix timestamp before which the transaction can't be mined")
+	sendRelative := sendCmd.Int("relative", 0, "Number of blocks the spent outputs must be confirmed for")
+	sendRelativeTime := sendCmd.Int64("relativetime", 0, "Number of seconds the spent outputs must be confirmed for, rounded up to 512")
+	sendOut := sendCmd.String("out", "", "Write the signed transaction to FILE instead of sending it")
+	sendFee := sendCmd.Int("fee", 0, "Fee left to the miner")
+	sendStrategy := sendCmd.String("strategy", defaultCoinSelector, "Coin selection strategy: bnb, largest, smallest or privacy")
+	sendDryRun := sendCmd.Bool("dryrun", false, "Print the selected inputs, fee and change without sending")
+	sendInputs := sendCmd.String("inputs", "", "Comma separated txid:vout outputs to spend instead of selecting coins")
+	sendSigHash := sendCmd.String("sighash", "ALL", "Signature hash type: ALL, NONE or SINGLE, optionally with |ANYONECANPAY")
+
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	sendManyFrom := sendManyCmd.String("from", "", "Source wallet address")
+	sendManyFile := sendManyCmd.String("file", "", "JSON or CSV file of recipient addresses and amounts")
+	sendManyFee := sendManyCmd.Int("fee", 0, "Fee left to the miner")
+	sendManyMine := sendManyCmd.Bool("mine", false, "Mine immediately")
+	sendManyStrategy := sendManyCmd.String("strategy", defaultCoinSelector, "Coin selection strategy: bnb, largest, smallest or privacy")
+	sendManyDryRun := sendManyCmd.Bool("dryrun", false, "Print the selected inputs, fee and change without sending")
+
+	decodeRawTxCmd := flag.NewFlagSet("decoderawtx", flag.ExitOnError)
+	decodeRawTxIn := decodeRawTxCmd.String("in", "", "File containing the transaction")
+
+	sendRawTxCmd := flag.NewFlagSet("sendrawtx", flag.ExitOnError)
+	sendRawTxIn := sendRawTxCmd.String("in", "", "File containing the signed transaction")
+	sendRawTxMiner := sendRawTxCmd.String("miner", "", "Mine the transaction locally and send the reward to ADDRESS")
+
+	signMultisigTxCmd := flag.NewFlagSet("signmultisigtx", flag.ExitOnError)
+	signMultisigTxIn := signMultisigTxCmd.String("in", "", "File containing the partially signed transaction")
+	signMultisigTxAddress := signMultisigTxCmd.String("address", "", "Wallet address of the signer")
+	signMultisigTxSigHash := signMultisigTxCmd.String("sighash", "ALL", "Signature hash type: ALL, NONE or SINGLE, optionally with |ANYONECANPAY")
+
+	createPledgeCmd := flag.NewFlagSet("createpledge", flag.ExitOnError)
+	createPledgeTo := createPledgeCmd.String("to", "", "Address receiving the pledges")
+	createPledgeGoal := createPledgeCmd.Int("goal", 0, "Amount to raise")
+	createPledgeOut := createPledgeCmd.String("out", "", "File to write the transaction to")
+
+	pledgeCmd := flag.NewFlagSet("pledge", flag.ExitOnError)
+	pledgeIn := pledgeCmd.String("in", "", "File containing the crowdfunding transaction")
+	pledgeFrom := pledgeCmd.String("from", "", "Contributing wallet address")
+	pledgeAmount := pledgeCmd.Int("amount", 0, "Amount to contribute")
+
+	redeemSwapCmd := flag.NewFlagSet("redeemswap", flag.ExitOnError)
+	redeemSwapContract := redeemSwapCmd.String("contract", "", "Hex swap contract")
+	redeemSwapTxid := redeemSwapCmd.String("txid", "", "Transaction paying to the contract")
+	redeemSwapSecret := redeemSwapCmd.String("secret", "", "Hex secret of the contract")
+	redeemSwapMine := redeemSwapCmd.Bool("mine", false, "Mine immediately")
+
+	refundSwapCmd := flag.NewFlagSet("refundswap", flag.ExitOnError)
+	refundSwapContract := refundSwapCmd.String("contract", "", "Hex swap contract")
+	refundSwapTxid := refundSwapCmd.String("txid", "", "Transaction paying to the contract")
+	refundSwapMine := refundSwapCmd.Bool("mine", false, "Mine immediately")
 
 	reindexUTXOcmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
 
+	verifyAnchorCmd := flag.NewFlagSet("verifyanchor", flag.ExitOnError)
+	verifyAnchorData := verifyAnchorCmd.String("data", "", "Hex data to look up")
+	verifyAnchorFile := verifyAnchorCmd.String("file", "", "File whose SHA-256 hash is looked up")
+	verifyAnchorHeight := verifyAnchorCmd.Int("height", -1, "Height of the block committing the data")
+
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	verifyChainDepth := verifyChainCmd.Int("depth", 6, "Number of blocks below the tip to check, 0 for all of them")
+	verifyChainLevel := verifyChainCmd.Int("level", verifyUTXOSet, "How thorough the checks are, from 0 to 3")
+
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeTxIndex := startNodeCmd.Bool("txindex", false, "Maintain the transaction index, building it for the existing chain in the background")
+	startNodeAddrIndex := startNodeCmd.Bool("addrindex", false, "Maintain the address index, building it for the existing chain in the background")
+	startNodeDropTxIndex := startNodeCmd.Bool("droptxindex", false, "Delete the transaction index")
+	startNodeDropAddrIndex := startNodeCmd.Bool("dropaddrindex", false, "Delete the address index")
+	startNodeUTXOCache := startNodeCmd.Int("utxocache", defaultUTXOCacheMB, "Megabytes of UTXO changes kept in memory before they are written to disk")
+	startNodePrune := startNodeCmd.Int("prune", 0, "Keep the transactions of only the last N blocks; a pruned chain stays pruned")
 
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately")
 
 	switch os.Args[1] {
+	case "anchor":
+		err := anchorCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "auditswap":
+		err := auditSwapCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
 	case "createblockchain":
 		err := createBlockchainCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
 
+	case "createmultisig":
+		err := createMultisigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "createmultisigtx":
+		err := createMultisigTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
 	case "createwallet":
 		err := createWalletCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -80,18 +283,120 @@ func (cli *CLI) Run() {
 			log.Panic(err)
 		}
 
+	case "dumptxoutset":
+		err := dumpTxOutSetCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "exportblocks":
+		err := exportBlocksCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "importblocks":
+		err := importBlocksCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "loadtxoutset":
+		err := loadTxOutSetCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "getblock":
+		err := getBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "getblockhash":
+		err := getBlockHashCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "gettransaction":
+		err := getTransactionCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "getpubkey":
+		err := getPubKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "initiateswap":
+		err := initiateSwapCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
 	case "listaddresses":
 		err := listAddressesCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
 
+	case "listunspent":
+		err := listUnspentCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "lockunspent":
+		err := lockUnspentCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "unlockunspent":
+		err := unlockUnspentCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "createpledge":
+		err := createPledgeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "pledge":
+		err := pledgeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
 	case "printchain":
 		err := printChainCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
 
+	case "redeemswap":
+		err := redeemSwapCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "refundswap":
+		err := refundSwapCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
 	case "reindexutxo":
 		err := reindexUTXOcmd.Parse(os.Args[2:])
 		if err != nil {
@@ -104,6 +409,42 @@ func (cli *CLI) Run() {
 			log.Panic(err)
 		}
 
+	case "sendmany":
+		err := sendManyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "decoderawtx":
+		err := decodeRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "sendrawtx":
+		err := sendRawTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "signmultisigtx":
+		err := signMultisigTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "verifyanchor":
+		err := verifyAnchorCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
+	case "verifychain":
+		err := verifyChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+
 	case "startnode":
 		err := startNodeCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -115,16 +456,52 @@ func (cli *CLI) Run() {
 		os.Exit(1)
 	}
 
+	if anchorCmd.Parsed() {
+		if *anchorFrom == "" || (*anchorData == "") == (*anchorFile == "") || *anchorFee < 0 {
+			anchorCmd.Usage()
+			os.Exit(1)
+		}
+		cli.anchor(*anchorFrom, *anchorData, *anchorFile, *anchorFee, nodeID, *anchorMine)
+	}
+
+	if auditSwapCmd.Parsed() {
+		if *auditSwapContract == "" || *auditSwapTxid == "" {
+			auditSwapCmd.Usage()
+			os.Exit(1)
+		}
+		cli.auditSwap(*auditSwapContract, *auditSwapTxid, nodeID)
+	}
+
 	if createBlockchainCmd.Parsed() {
 		if *createBlockchainAddress == "" {
 			createBlockchainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockchain(*createBlockchainAddress, nodeID)
+		cli.createBlockchain(*createBlockchainAddress, selectIndexes(*createBlockchainTxIndex, *createBlockchainAddrIndex), nodeID)
+	}
+
+	if createMultisigCmd.Parsed() {
+		if *createMultisigRequired <= 0 || *createMultisigPubKeys == "" {
+			createMultisigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisig(*createMultisigRequired, *createMultisigPubKeys)
+	}
+
+	if createMultisigTxCmd.Parsed() {
+		if *createMultisigTxRedeem == "" || *createMultisigTxTo == "" || *createMultisigTxAmount <= 0 || *createMultisigTxFee < 0 || *createMultisigTxOut == "" {
+			createMultisigTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisigTx(*createMultisigTxRedeem, *createMultisigTxTo, *createMultisigTxAmount, *createMultisigTxFee, *createMultisigTxOut, nodeID)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		kind, err := parseKeyType(*createWalletType)
+		if err != nil {
+			log.Panic(err)
+		}
+		cli.createWallet(kind, nodeID)
 	}
 
 	if getBalanceCmd.Parsed() {
@@ -135,24 +512,239 @@ func (cli *CLI) Run() {
 		cli.getBalance(*getBalanceAddress, nodeID)
 	}
 
+	if dumpTxOutSetCmd.Parsed() {
+		if *dumpTxOutSetOut == "" {
+			dumpTxOutSetCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpTxOutSet(*dumpTxOutSetOut, *dumpTxOutSetHeight, nodeID)
+	}
+
+	if exportBlocksCmd.Parsed() {
+		if *exportBlocksOut == "" || *exportBlocksFrom < 0 {
+			exportBlocksCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportBlocks(*exportBlocksOut, *exportBlocksFrom, *exportBlocksTo, nodeID)
+	}
+
+	if importBlocksCmd.Parsed() {
+		if *importBlocksIn == "" {
+			importBlocksCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importBlocks(*importBlocksIn, nodeID)
+	}
+
+	if loadTxOutSetCmd.Parsed() {
+		if *loadTxOutSetIn == "" {
+			loadTxOutSetCmd.Usage()
+			os.Exit(1)
+		}
+		cli.loadTxOutSet(*loadTxOutSetIn, nodeID)
+	}
+
+	if getBlockCmd.Parsed() {
+		if (*getBlockHash == "") == (*getBlockHeight < 0) {
+			getBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlock(*getBlockHash, *getBlockHeight, nodeID)
+	}
+
+	if getBlockHashCmd.Parsed() {
+		if *getBlockHashHeight < 0 || *getBlockHashCount <= 0 {
+			getBlockHashCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlockHash(*getBlockHashHeight, *getBlockHashCount, nodeID)
+	}
+
+	if getTransactionCmd.Parsed() {
+		if *getTransactionTxid == "" {
+			getTransactionCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getTransaction(*getTransactionTxid, nodeID)
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" || *historyOffset < 0 || *historyLimit <= 0 {
+			historyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.history(*historyAddress, *historyOffset, *historyLimit, nodeID)
+	}
+
+	if getPubKeyCmd.Parsed() {
+		if *getPubKeyAddress == "" {
+			getPubKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getPubKey(*getPubKeyAddress, nodeID)
+	}
+
+	if initiateSwapCmd.Parsed() {
+		if *initiateSwapFrom == "" || *initiateSwapTo == "" || *initiateSwapAmount <= 0 || *initiateSwapFee < 0 || *initiateSwapLockTime <= 0 {
+			initiateSwapCmd.Usage()
+			os.Exit(1)
+		}
+		cli.initiateSwap(*initiateSwapFrom, *initiateSwapTo, *initiateSwapAmount, *initiateSwapFee, *initiateSwapLockTime, *initiateSwapSecretHash, nodeID, *initiateSwapMine)
+	}
+
 	if listAddressesCmd.Parsed() {
 		cli.listAddresses(nodeID)
 	}
 
+	if listUnspentCmd.Parsed() {
+		if *listUnspentAddress == "" {
+			listUnspentCmd.Usage()
+			os.Exit(1)
+		}
+		cli.listUnspent(*listUnspentAddress, nodeID)
+	}
+
+	if lockUnspentCmd.Parsed() {
+		if *lockUnspentOutputs == "" {
+			lockUnspentCmd.Usage()
+			os.Exit(1)
+		}
+		cli.lockUnspent(*lockUnspentOutputs, true, nodeID)
+	}
+
+	if unlockUnspentCmd.Parsed() {
+		if *unlockUnspentOutputs == "" {
+			unlockUnspentCmd.Usage()
+			os.Exit(1)
+		}
+		cli.lockUnspent(*unlockUnspentOutputs, false, nodeID)
+	}
+
 	if printChainCmd.Parsed() {
 		cli.printChain(nodeID)
 	}
 
+	if redeemSwapCmd.Parsed() {
+		if *redeemSwapContract == "" || *redeemSwapTxid == "" || *redeemSwapSecret == "" {
+			redeemSwapCmd.Usage()
+			os.Exit(1)
+		}
+		cli.redeemSwap(*redeemSwapContract, *redeemSwapTxid, *redeemSwapSecret, nodeID, *redeemSwapMine)
+	}
+
+	if refundSwapCmd.Parsed() {
+		if *refundSwapContract == "" || *refundSwapTxid == "" {
+			refundSwapCmd.Usage()
+			os.Exit(1)
+		}
+		cli.refundSwap(*refundSwapContract, *refundSwapTxid, nodeID, *refundSwapMine)
+	}
+
 	if reindexUTXOcmd.Parsed() {
 		cli.reindexUTXO(nodeID)
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendLockTime < 0 || *sendRelative < 0 || *sendRelative > int(sequenceMask) || *sendFee < 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		if *sendRelativeTime < 0 || *sendRelativeTime > int64(sequenceMask)<<sequenceGranularity || (*sendRelative > 0 && *sendRelativeTime > 0) {
+			sendCmd.Usage()
+			os.Exit(1)
+		}
+		selector, err := getCoinSelector(*sendStrategy)
+		if err != nil {
+			log.Panic(err)
+		}
+		opts := txOptions{Fee: *sendFee, LockTime: *sendLockTime, Sequence: relativeLockBlocks(*sendRelative), Selector: selector}
+		if *sendRelativeTime > 0 {
+			opts.Sequence = relativeLockSeconds(*sendRelativeTime)
+		}
+		opts.SigHash, err = parseSigHashType(*sendSigHash)
+		if err != nil {
+			log.Panic(err)
+		}
+		if *sendInputs != "" {
+			opts.Inputs, err = parseOutpoints(*sendInputs)
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+		cli.send(*sendFrom, *sendTo, *sendAmount, opts, *sendOut, nodeID, *sendMine, *sendDryRun)
+	}
+
+	if sendManyCmd.Parsed() {
+		if *sendManyFrom == "" || *sendManyFile == "" || *sendManyFee < 0 {
+			sendManyCmd.Usage()
+			os.Exit(1)
+		}
+		selector, err := getCoinSelector(*sendManyStrategy)
+		if err != nil {
+			log.Panic(err)
+		}
+		opts := txOptions{Fee: *sendManyFee, Selector: selector}
+		cli.sendMany(*sendManyFrom, *sendManyFile, opts, nodeID, *sendManyMine, *sendManyDryRun)
+	}
+
+	if decodeRawTxCmd.Parsed() {
+		if *decodeRawTxIn == "" {
+			decodeRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.decodeRawTx(*decodeRawTxIn)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxIn == "" {
+			sendRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendRawTx(*sendRawTxIn, *sendRawTxMiner, nodeID)
+	}
+
+	if signMultisigTxCmd.Parsed() {
+		if *signMultisigTxIn == "" || *signMultisigTxAddress == "" {
+			signMultisigTxCmd.Usage()
+			os.Exit(1)
+		}
+		hashType, err := parseSigHashType(*signMultisigTxSigHash)
+		if err != nil {
+			log.Panic(err)
+		}
+		cli.signMultisigTx(*signMultisigTxIn, *signMultisigTxAddress, hashType, nodeID)
+	}
+
+	if createPledgeCmd.Parsed() {
+		if *createPledgeTo == "" || *createPledgeGoal <= 0 || *createPledgeOut == "" {
+			createPledgeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createPledge(*createPledgeTo, *createPledgeGoal, *createPledgeOut)
+	}
+
+	if pledgeCmd.Parsed() {
+		if *pledgeIn == "" || *pledgeFrom == "" || *pledgeAmount <= 0 {
+			pledgeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.pledge(*pledgeIn, *pledgeFrom, *pledgeAmount, nodeID)
+	}
+
+	if verifyAnchorCmd.Parsed() {
+		if (*verifyAnchorData == "") == (*verifyAnchorFile == "") || *verifyAnchorHeight < 0 {
+			verifyAnchorCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyAnchor(*verifyAnchorData, *verifyAnchorFile, *verifyAnchorHeight, nodeID)
+	}
+
+	if verifyChainCmd.Parsed() {
+		if *verifyChainDepth < 0 || *verifyChainLevel < verifyHeaders || *verifyChainLevel > verifyUTXOSet {
+			verifyChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyChain(*verifyChainDepth, *verifyChainLevel, nodeID)
 	}
 
 	if startNodeCmd.Parsed() {
@@ -161,8 +753,42 @@ func (cli *CLI) Run() {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
+		if *startNodeUTXOCache <= 0 || *startNodePrune < 0 {
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		if (*startNodeTxIndex && *startNodeDropTxIndex) || (*startNodeAddrIndex && *startNodeDropAddrIndex) {
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		utxoCacheBudget = *startNodeUTXOCache << 20
+
+		indexes := selectIndexes(*startNodeTxIndex, *startNodeAddrIndex)
+		dropIndexes := selectIndexes(*startNodeDropTxIndex, *startNodeDropAddrIndex)
+		cli.startNode(nodeID, *startNodeMiner, indexes, dropIndexes, *startNodePrune)
+	}
+
+}
+
+// selectIndexes returns the transaction and address indexes picked by a pair of flags
+func selectIndexes(withTxIndex, withAddrIndex bool) []chainIndex {
+	var indexes []chainIndex
+	if withTxIndex {
+		indexes = append(indexes, txIndex{})
+	}
+	if withAddrIndex {
+		indexes = append(indexes, addrIndex{})
+	}
+
+	return indexes
+}
 
-		cli.startNode(nodeID, *startNodeMiner)
+// decodeHexArg decodes a hex command line argument or panics naming it
+func decodeHexArg(value string, name string) []byte {
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		log.Panicf("ERROR: %s is not valid hex", name)
 	}
 
+	return data
 }
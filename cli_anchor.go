@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) anchor(from string, dataHex string, file string, fee int, nodeID string, mineNow bool) {
+	if !validateAddress(from) {
+		log.Panic("ERROR: Sender address is not valid")
+	}
+
+	data := anchorPayload(dataHex, file)
+
+	bc := newBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	wallets, err := newWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.getWallet(from)
+
+	opts := txOptions{Fee: fee, Selector: coinSelectors[defaultCoinSelector]}
+	tx := newAnchorTransaction(&wallet, data, opts, &UTXOSet)
+
+	minerAddress := ""
+	if mineNow {
+		minerAddress = from
+	}
+	submitTransaction(bc, tx, minerAddress)
+
+	fmt.Printf("Anchored %x in transaction %x\n", data, tx.ID)
+}
+
+// anchorPayload returns the hex data or, for a file, the SHA-256 hash of its contents
+func anchorPayload(dataHex string, file string) []byte {
+	if file == "" {
+		return decodeHexArg(dataHex, "Data")
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	hash := sha256.Sum256(content)
+
+	return hash[:]
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) auditSwap(contractHex string, txidHex string, nodeID string) {
+	contract, err := deserializeHTLCContract(decodeHexArg(contractHex, "Contract"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	contractTx, err := bc.findTransaction(decodeHexArg(txidHex, "Transaction ID"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	vout, err := findContractOutput(&contractTx, contract)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Contract output: %x:%d\n", contractTx.ID, vout)
+	fmt.Printf("Amount: %d\n", contractTx.Vout[vout].Value)
+	fmt.Printf("Recipient: %s\n", encodeAddress(contract.RecipientVersion, contract.RecipientPubKeyHash))
+	fmt.Printf("Refund: %s\n", encodeAddress(contract.RefundVersion, contract.RefundPubKeyHash))
+	fmt.Printf("Secret hash: %x\n", contract.SecretHash)
+	if contract.LockTime < lockTimeThreshold {
+		fmt.Printf("Lock time: height %d (current height %d)\n", contract.LockTime, bc.getBestHeight())
+	} else {
+		fmt.Printf("Lock time: unix time %d\n", contract.LockTime)
+	}
+
+	spendingTx, inID, err := bc.findSpendingTransaction(contractTx.ID, vout)
+	if err != nil {
+		fmt.Println("Status: unspent")
+		return
+	}
+
+	preimage := spendingTx.Vin[inID].Preimage
+	if len(preimage) > 0 {
+		fmt.Printf("Status: redeemed in %x\n", spendingTx.ID)
+		fmt.Printf("Secret: %x\n", preimage)
+	} else {
+		fmt.Printf("Status: refunded in %x\n", spendingTx.ID)
+	}
+}
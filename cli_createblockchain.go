@@ -5,15 +5,17 @@ import (
 	"log"
 )
 
-func (cli *CLI) createBlockchain(address string, nodeID string) {
+func (cli *CLI) createBlockchain(address string, indexes []chainIndex, nodeID string) {
 	if !validateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
 	bc := createBlockchain(address, nodeID)
 	defer bc.db.Close()
 
-	UTXOSet := UTXOSet{bc}
-	UTXOSet.reindex()
+	for _, index := range indexes {
+		bc.enableIndex(index)
+	}
+	bc.syncIndexes()
 
 	fmt.Println("Done!")
 }
\ No newline at end of file
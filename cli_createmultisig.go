@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+)
+
+func (cli *CLI) createMultisig(required int, pubKeysHex string) {
+	var pubKeys [][]byte
+
+	for _, keyHex := range strings.Split(pubKeysHex, ",") {
+		pubKey, err := hex.DecodeString(strings.TrimSpace(keyHex))
+		if err != nil {
+			log.Panic("ERROR: Public key is not valid hex")
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	script, err := newMultisigScript(required, pubKeys)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Multisig address: %s\n", script.getAddress())
+	fmt.Printf("Redeem script: %x\n", script.serialize())
+}
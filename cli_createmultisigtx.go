@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) createMultisigTx(redeemHex string, to string, amount int, fee int, out string, nodeID string) {
+	if !validateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+
+	redeemScript, err := hex.DecodeString(redeemHex)
+	if err != nil {
+		log.Panic("ERROR: Redeem script is not valid hex")
+	}
+
+	script, err := deserializeMultisigScript(redeemScript)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := newBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	opts := txOptions{Fee: fee, Selector: coinSelectors[defaultCoinSelector]}
+	tx := newMultisigTransaction(script, to, amount, opts, &UTXOSet)
+	saveTransactionFile(out, tx)
+
+	fmt.Printf("Unsigned transaction %x written to %s, %d of %d signatures required\n", tx.ID, out, script.Required, len(script.PubKeys))
+}
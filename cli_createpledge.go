@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// createPledge writes a transaction paying goal to address with no inputs,
+// contributors add and sign their inputs with pledge
+func (cli *CLI) createPledge(to string, goal int, out string) {
+	if !validateAddress(to) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	tx := Transaction{Vout: []TXOutput{*newTXOutput(goal, to)}}
+	tx.setTXID()
+	saveTransactionFile(out, &tx)
+
+	fmt.Printf("Pledge of %d to %s written to %s\n", goal, to, out)
+}
@@ -2,9 +2,9 @@ package main
 
 import "fmt"
 
-func (cli *CLI) createWallet(nodeID string) {
+func (cli *CLI) createWallet(kind keyType, nodeID string) {
 	wallets, _ := newWallets(nodeID)
-	address := wallets.createWallet()
+	address := wallets.createWallet(kind)
 	wallets.saveToFile(nodeID)
 
 	fmt.Printf("Your new address: %s\n", address)
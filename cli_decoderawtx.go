@@ -0,0 +1,12 @@
+package main
+
+import "fmt"
+
+func (cli *CLI) decodeRawTx(in string) {
+	tx := loadTransactionFile(in)
+
+	fmt.Printf("TXID: %x\n", tx.ID)
+	fmt.Printf("Witness hash: %x\n", tx.witnessHash())
+	fmt.Printf("Version: %d\n", tx.Version)
+	fmt.Println(tx.toString())
+}
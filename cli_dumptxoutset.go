@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) dumpTxOutSet(out string, height int, nodeID string) {
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	if height < 0 {
+		height = bc.getBestHeight()
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	base, count, err := bc.dumpUTXOSet(w, height)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(out)
+		log.Panic("ERROR: Can't dump the UTXO set: ", err)
+	}
+
+	fmt.Printf("Wrote the UTXO set at height %d (block %x) to %s: %d outputs\n", base.Height, base.BlockHash, out, count)
+	fmt.Printf("Hash: %x\n", base.Hash)
+	fmt.Printf("Nodes load it with ASSUMEUTXO=%d:%x loadtxoutset -in %s\n", base.Height, base.Hash, out)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) exportBlocks(out string, from int, to int, nodeID string) {
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	if to < 0 {
+		to = bc.getBestHeight()
+	}
+	if from > to || to > bc.getBestHeight() {
+		log.Panicf("ERROR: Heights %d to %d are not in the chain", from, to)
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	count, err := bc.exportBlocks(w, from, to)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(out)
+		log.Panic("ERROR: Can't export the blocks: ", err)
+	}
+
+	fmt.Printf("Exported %d blocks, heights %d to %d, to %s\n", count, from, to, out)
+}
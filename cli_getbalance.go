@@ -16,8 +16,7 @@ func (cli *CLI) getBalance(address string, nodeID string) {
 	defer bc.db.Close()
 
 	balance := 0
-	pubKeyHash := base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	_, pubKeyHash := decodeAddress([]byte(address))
 	UTXOs := UTXOSet.findUTXO(pubKeyHash)
 
 	for _, out := range UTXOs {
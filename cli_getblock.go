@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) getBlock(hashHex string, height int, nodeID string) {
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	var block *block
+	var err error
+	if hashHex != "" {
+		block, err = bc.getBlock(decodeHexArg(hashHex, "hash"))
+	} else {
+		block, err = bc.getBlockAtHeight(height)
+	}
+	if err != nil {
+		log.Panic("ERROR: ", err)
+	}
+
+	printBlock(block)
+	fmt.Printf("Confirmations: %d\n", bc.getBestHeight()-block.Height+1)
+}
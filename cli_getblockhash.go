@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) getBlockHash(height int, count int, nodeID string) {
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	hashes := bc.getBlockHashesInRange(height, height+count-1)
+	if len(hashes) == 0 {
+		log.Panic("ERROR: Block is not found")
+	}
+
+	for i, hash := range hashes {
+		fmt.Printf("%6d  %x\n", height+i, hash)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) getPubKey(address string, nodeID string) {
+	wallets, err := newWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if wallets.Wallets[address] == nil {
+		log.Panic("ERROR: Address is not in the wallet file")
+	}
+	wallet := wallets.getWallet(address)
+
+	fmt.Printf("%x\n", wallet.PublicKey)
+}
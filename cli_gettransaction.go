@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+func (cli *CLI) getTransaction(txidHex string, nodeID string) {
+	txid, err := hex.DecodeString(txidHex)
+	if err != nil {
+		fmt.Println("ERROR: txid is not valid hex")
+		os.Exit(1)
+	}
+
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	if !bc.txIndexed() {
+		fmt.Println("Transaction index is not available, scanning the chain")
+	}
+
+	block, err := bc.findTransactionBlock(txid)
+	if err != nil {
+		fmt.Printf("ERROR: Can't find transaction %x: %s\n", txid, err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	for pos, tx := range block.Transactions {
+		if !bytes.Equal(tx.ID, txid) {
+			continue
+		}
+
+		fmt.Printf("TXID: %x\n", tx.ID)
+		fmt.Printf("Witness hash: %x\n", tx.witnessHash())
+		fmt.Printf("Block: %x\n", block.Hash)
+		fmt.Printf("Height: %d\n", block.Height)
+		fmt.Printf("Position: %d\n", pos)
+		fmt.Printf("Confirmations: %d\n", bc.getBestHeight()-block.Height+1)
+		fmt.Println(tx.toString())
+	}
+}
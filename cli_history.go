@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) history(address string, offset int, limit int, nodeID string) {
+	if !validateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	_, pubKeyHash := decodeAddress([]byte(address))
+
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	history, err := bc.addressHistory(pubKeyHash)
+	if err != nil {
+		log.Panic("ERROR: ", err)
+	}
+
+	balances := make([]int, len(history))
+	balance := 0
+	for i, entry := range history {
+		balance += entry.Received - entry.Sent
+		balances[i] = balance
+	}
+
+	fmt.Printf("History of %s: %d transactions, balance %d\n", address, len(history), balance)
+
+	for i := len(history) - 1 - offset; i >= 0 && i >= len(history)-offset-limit; i-- {
+		entry := history[i]
+		direction := "received"
+		if entry.Sent > 0 {
+			direction = "sent"
+		}
+
+		fmt.Printf("%6d  %x  %-8s  %+d  balance %d\n", entry.Height, entry.TxID, direction, entry.Received-entry.Sent, balances[i])
+	}
+}
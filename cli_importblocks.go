@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+func (cli *CLI) importBlocks(in string, nodeID string) {
+	file, err := os.Open(in)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	blocks, err := newBlockFileReader(bufio.NewReader(file))
+	if err != nil {
+		log.Panic("ERROR: Can't import the blocks: ", err)
+	}
+
+	var bc *blockchain
+	imported, known := 0, 0
+
+	if dbExists(fmt.Sprintf(activeChain.DBFile, nodeID)) {
+		bc = newBlockchain(nodeID)
+	}
+	defer func() {
+		if bc != nil {
+			bc.db.Close()
+		}
+	}()
+
+	for {
+		b, err := blocks.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panic("ERROR: Can't import the blocks: ", err)
+		}
+
+		if bc == nil { // a new blockchain starting at the genesis block of the file
+			err = checkGenesis(b)
+			if err != nil {
+				log.Panic("ERROR: There is no blockchain to add the blocks to, and ", err)
+			}
+			bc = createBlockchainFromGenesis(b, nodeID)
+			imported++
+			continue
+		}
+
+		added, err := bc.importBlock(b)
+		if err != nil {
+			log.Panicf("ERROR: Stopped after importing %d blocks: %s", imported, err)
+		}
+		if added {
+			imported++
+		} else {
+			known++
+		}
+	}
+
+	if bc == nil {
+		log.Panic("ERROR: The file holds no blocks")
+	}
+	bc.syncIndexes()
+
+	fmt.Printf("Imported %d blocks, %d were already stored. The tip is at height %d\n", imported, known, bc.getBestHeight())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) initiateSwap(from string, to string, amount int, fee int, lockTime int64, secretHashHex string, nodeID string, mineNow bool) {
+	if !validateAddress(from) {
+		log.Panic("ERROR: Sender address is not valid")
+	}
+
+	if !validateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+
+	var secret, secretHash []byte
+	if secretHashHex == "" {
+		secret, secretHash = newSecret()
+	} else {
+		var err error
+		secretHash, err = hex.DecodeString(secretHashHex)
+		if err != nil {
+			log.Panic("ERROR: Secret hash is not valid hex")
+		}
+	}
+
+	contract, err := newHTLCContract(secretHash, to, from, lockTime)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := newBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	wallets, err := newWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.getWallet(from)
+
+	opts := txOptions{Fee: fee, Selector: coinSelectors[defaultCoinSelector]}
+	tx := newHTLCTransaction(&wallet, contract, amount, opts, &UTXOSet)
+
+	minerAddress := ""
+	if mineNow {
+		minerAddress = from
+	}
+	submitTransaction(bc, tx, minerAddress)
+
+	if secret != nil {
+		fmt.Printf("Secret: %x\n", secret)
+	}
+	fmt.Printf("Secret hash: %x\n", secretHash)
+	fmt.Printf("Contract: %x\n", contract.serialize())
+	fmt.Printf("Contract transaction: %x\n", tx.ID)
+}
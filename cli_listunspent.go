@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) listUnspent(address string, nodeID string) {
+	if !validateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	bc := newBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	_, pubKeyHash := decodeAddress([]byte(address))
+	locked := UTXOSet.lockedOutpoints()
+	bestHeight := bc.getBestHeight()
+
+	for _, out := range UTXOSet.listUnspent(pubKeyHash) {
+		o := outpoint{out.TxID, out.Vout}
+
+		confirmations := bestHeight - out.Height + 1
+
+		status := ""
+		if locked[string(o.key())] {
+			status = " locked"
+		}
+
+		fmt.Printf("%s value %d confirmations %d%s\n", o, out.Value, confirmations, status)
+	}
+}
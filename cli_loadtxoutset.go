@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) loadTxOutSet(in string, nodeID string) {
+	dbFile := fmt.Sprintf(activeChain.DBFile, nodeID)
+	if dbExists(dbFile) {
+		fmt.Println("Blockchain already exists.")
+		os.Exit(1)
+	}
+
+	file, err := os.Open(in)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	store, err := openBoltStore(dbFile)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	base, count, err := loadUTXOSnapshot(bufio.NewReader(file), store)
+	store.Close()
+	if err != nil {
+		os.Remove(dbFile)
+		log.Panic("ERROR: Can't load the UTXO snapshot: ", err)
+	}
+
+	fmt.Printf("Loaded the UTXO set at height %d (block %x): %d outputs\n", base.Height, base.BlockHash, count)
+	fmt.Println("The blocks below it are downloaded and checked in the background once the node starts")
+}
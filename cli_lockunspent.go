@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) lockUnspent(outputs string, lock bool, nodeID string) {
+	outpoints, err := parseOutpoints(outputs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := newBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	for _, o := range outpoints {
+		if lock && !UTXOSet.isUnspent(o) {
+			log.Panicf("ERROR: Output %s is not unspent", o)
+		}
+	}
+
+	UTXOSet.setLocked(outpoints, lock)
+
+	for _, o := range outpoints {
+		if lock {
+			fmt.Printf("Locked %s\n", o)
+		} else {
+			fmt.Printf("Unlocked %s\n", o)
+		}
+	}
+}
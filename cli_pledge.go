@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// pledge adds inputs of from worth exactly amount to the crowdfunding
+// transaction in FILE. The inputs are signed ALL|ANYONECANPAY so they stay
+// valid as other contributors add theirs, but only once the goal is met.
+// The transaction has no output for change, so anything over amount would
+// go to the miner: without outputs adding up to amount the pledge is refused
+func (cli *CLI) pledge(in string, from string, amount int, nodeID string) {
+	if !validateAddress(from) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	wallets, err := newWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if wallets.Wallets[from] == nil {
+		log.Panic("ERROR: Address is not in the wallet file")
+	}
+	wallet := wallets.getWallet(from)
+
+	bc := newBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	tx := loadTransactionFile(in)
+
+	var candidates []spendableOutput
+	for _, candidate := range UTXOSet.findSpendableCandidates(hashPubKey(wallet.PublicKey)) {
+		if !spendsOutput(&tx, candidate.TxID, candidate.Vout) {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	selected, ok := exactMatch(candidates, amount)
+	if !ok {
+		log.Panicf("ERROR: No outputs of %s add up to exactly %d, send %d to %s first to make one", from, amount, amount, from)
+	}
+
+	// with ANYONECANPAY a signature only covers its own input, so the new
+	// inputs are signed apart from the ones already pledged
+	pledged := Transaction{Vout: tx.Vout, LockTime: tx.LockTime}
+	value := 0
+	for _, in := range selected {
+		pledged.Vin = append(pledged.Vin, TXInput{Txid: in.TxID, Vout: in.Vout, PubKey: wallet.PublicKey})
+		value += in.Value
+	}
+	bc.signTransaction(&pledged, &wallet, sigHashAll|sigHashAnyoneCanPay)
+
+	tx.Vin = append(tx.Vin, pledged.Vin...)
+	tx.setTXID()
+	saveTransactionFile(in, &tx)
+
+	goal := 0
+	for _, out := range tx.Vout {
+		goal += out.Value
+	}
+	fee, err := bc.transactionFee(&tx)
+	if err != nil {
+		log.Panic("ERROR: ", err)
+	}
+	total := goal + fee
+
+	fmt.Printf("Pledged %d from %s\n", value, from)
+	if total >= goal {
+		fmt.Printf("Pledges total %d of %d, transaction is complete\n", total, goal)
+	} else {
+		fmt.Printf("Pledges total %d of %d\n", total, goal)
+	}
+}
+
+// spendsOutput reports whether tx already has an input spending txid:vout
+func spendsOutput(tx *Transaction, txid []byte, vout int) bool {
+	for _, vin := range tx.Vin {
+		if vin.Vout == vout && bytes.Equal(vin.Txid, txid) {
+			return true
+		}
+	}
+
+	return false
+}
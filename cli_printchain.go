@@ -14,18 +14,28 @@ func (cli *CLI) printChain(nodeID string) {
 	for {
 		block := bci.next()
 
-		fmt.Printf("============ Block %x ============\n", block.Hash)
-		fmt.Printf("Height: %d\n", block.Height)
-		fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
-		pow := newPow(block)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
-		}
+		printBlock(block)
 		fmt.Printf("\n\n")
 
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
 	}
+}
+
+func printBlock(block *block) {
+	fmt.Printf("============ Block %x ============\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
+	if block.Version >= 2 && !block.Pruned {
+		fmt.Printf("Witness root: %x\n", block.hashWitnesses())
+	}
+	pow := newPow(block)
+	fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.validate()))
+	if block.Pruned {
+		fmt.Println("Transactions pruned")
+	}
+	for _, tx := range block.Transactions {
+		fmt.Println(tx.toString())
+	}
 }
\ No newline at end of file
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) redeemSwap(contractHex string, txidHex string, secretHex string, nodeID string, mineNow bool) {
+	secret := decodeHexArg(secretHex, "Secret")
+	spendSwap(contractHex, txidHex, secret, nodeID, mineNow)
+}
+
+// spendSwap redeems the contract output with secret or refunds it when secret is nil
+func spendSwap(contractHex string, txidHex string, secret []byte, nodeID string, mineNow bool) {
+	contract, err := deserializeHTLCContract(decodeHexArg(contractHex, "Contract"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	contractTx, err := bc.findTransaction(decodeHexArg(txidHex, "Transaction ID"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	vout, err := findContractOutput(&contractTx, contract)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, err := newWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	spender := contract.RecipientPubKeyHash
+	if secret == nil {
+		spender = contract.RefundPubKeyHash
+	}
+	wallet := wallets.findWallet(spender)
+	if wallet == nil {
+		log.Panic("ERROR: The wallet file has no key for this side of the contract")
+	}
+
+	tx := newHTLCSpendTransaction(wallet, contract, &contractTx, vout, secret, bc)
+
+	minerAddress := ""
+	if mineNow {
+		minerAddress = string(wallet.getAddress())
+	}
+	submitTransaction(bc, tx, minerAddress)
+
+	fmt.Printf("Spent contract output %x:%d in transaction %x\n", contractTx.ID, vout, tx.ID)
+}
@@ -0,0 +1,5 @@
+package main
+
+func (cli *CLI) refundSwap(contractHex string, txidHex string, nodeID string, mineNow bool) {
+	spendSwap(contractHex, txidHex, nil, nodeID, mineNow)
+}
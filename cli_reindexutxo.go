@@ -6,6 +6,7 @@ import (
 
 func (cli* CLI) reindexUTXO(nodeID string) {
 	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
 	UTXOSet := UTXOSet{bc}
 	UTXOSet.reindex()
 
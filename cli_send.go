@@ -5,7 +5,7 @@ import (
 	"log"
 )
 
-func (cli *CLI) send(from string, to string, amount int, nodeID string, mineNow bool) {
+func (cli *CLI) send(from string, to string, amount int, opts txOptions, out string, nodeID string, mineNow bool, dryRun bool) {
 	if !validateAddress(from) {
 		log.Panic("ERROR: Sender address is not valid")
 	}
@@ -26,17 +26,26 @@ func (cli *CLI) send(from string, to string, amount int, nodeID string, mineNow
 	// log.Println("Public key: ", wallet.PublicKey)
 	// log.Println("Private key: ", wallet.PrivateKey)
 
-	tx := newUTXOTransaction(&wallet, to, amount, &UTXOSet)
+	plan := planPayment(&wallet, []payment{{to, amount}}, opts, &UTXOSet)
+	if dryRun {
+		plan.print()
+		return
+	}
 
-	if mineNow {
-		cbTx := newCoinbaseTX(from, "")
-		txs := []*Transaction{cbTx, tx}
+	tx := newPaymentTransaction(&wallet, plan, opts, &UTXOSet)
 
-		newBlock := bc.mineBlock(txs)
-		UTXOSet.update(newBlock)
-	} else {
-		sendTx(knownNodes[0], tx)
+	if out != "" {
+		saveTransactionFile(out, tx)
+		fmt.Printf("Signed transaction %x written to %s\n", tx.ID, out)
+		fmt.Printf("Witness hash: %x\n", tx.witnessHash())
+		return
+	}
+
+	minerAddress := ""
+	if mineNow {
+		minerAddress = from
 	}
+	submitTransaction(bc, tx, minerAddress)
 
 	fmt.Println("Success!")
-}
\ No newline at end of file
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func (cli *CLI) sendMany(from string, file string, opts txOptions, nodeID string, mineNow bool, dryRun bool) {
+	if !validateAddress(from) {
+		log.Panic("ERROR: Sender address is not valid")
+	}
+
+	payments, err := loadPayments(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	total := 0
+	for _, p := range payments {
+		if !validateAddress(p.Address) {
+			log.Panicf("ERROR: Recipient address %s is not valid", p.Address)
+		}
+		if p.Amount <= 0 {
+			log.Panicf("ERROR: Amount for %s must be positive", p.Address)
+		}
+		total += p.Amount
+	}
+
+	bc := newBlockchain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	wallets, err := newWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.getWallet(from)
+
+	plan := planPayment(&wallet, payments, opts, &UTXOSet)
+	if dryRun {
+		plan.print()
+		return
+	}
+
+	tx := newPaymentTransaction(&wallet, plan, opts, &UTXOSet)
+
+	minerAddress := ""
+	if mineNow {
+		minerAddress = from
+	}
+	submitTransaction(bc, tx, minerAddress)
+
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Printf("Witness hash: %x\n", tx.witnessHash())
+	fmt.Printf("Recipients: %d\n", len(payments))
+	fmt.Printf("Total: %d\n", total)
+	fmt.Printf("Fee: %d\n", plan.Fee)
+	fmt.Printf("Change: %d\n", plan.Change)
+}
+
+// loadPayments reads address to amount pairs from a JSON object, a JSON
+// array of {"address", "amount"} objects or CSV lines of address,amount
+func loadPayments(path string) ([]payment, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var payments []payment
+	trimmed := bytes.TrimSpace(content)
+
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		payments, err = parsePaymentsObject(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		err = json.Unmarshal(trimmed, &payments)
+	default:
+		payments, err = parsePaymentsCSV(trimmed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("%s has no recipients", path)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range payments {
+		if seen[p.Address] {
+			return nil, fmt.Errorf("address %s is listed more than once", p.Address)
+		}
+		seen[p.Address] = true
+	}
+
+	return payments, nil
+}
+
+// parsePaymentsObject reads the address to amount pairs of a JSON object
+// token by token, in file order, so an address listed twice is kept twice
+// instead of the last amount silently replacing the first
+func parsePaymentsObject(content []byte) ([]payment, error) {
+	var payments []payment
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	if _, err := decoder.Token(); err != nil { // the opening brace
+		return nil, err
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		address := token.(string) // object keys are always strings
+
+		var amount int
+		err = decoder.Decode(&amount)
+		if err != nil {
+			return nil, fmt.Errorf("amount for %s: %s", address, err)
+		}
+
+		payments = append(payments, payment{address, amount})
+	}
+
+	if _, err := decoder.Token(); err != nil { // the closing brace
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("trailing data after the JSON object")
+	}
+
+	return payments, nil
+}
+
+func parsePaymentsCSV(content []byte) ([]payment, error) {
+	var payments []payment
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		amount, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			if line == 1 {
+				continue // header row
+			}
+			return nil, fmt.Errorf("line %d: invalid amount %q", line, record[1])
+		}
+
+		payments = append(payments, payment{strings.TrimSpace(record[0]), amount})
+	}
+
+	return payments, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) sendRawTx(in string, minerAddress string, nodeID string) {
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	tx := loadTransactionFile(in)
+	if !bc.verifyTransaction(&tx) {
+		log.Panic("ERROR: Transaction is not fully signed")
+	}
+
+	submitTransaction(bc, &tx, minerAddress)
+
+	fmt.Printf("Sent transaction %x\n", tx.ID)
+	fmt.Printf("Witness hash: %x\n", tx.witnessHash())
+}
+
+// submitTransaction mines tx locally with the reward going to minerAddress
+// or, when minerAddress is empty, sends it to the seed node
+func submitTransaction(bc *blockchain, tx *Transaction, minerAddress string) {
+	err := standardPolicy.checkStandard(tx)
+	if err != nil {
+		log.Panic("ERROR: Transaction is not standard: ", err)
+	}
+
+	if minerAddress == "" {
+		sendTx(knownNodes[0], tx)
+		return
+	}
+
+	if !validateAddress(minerAddress) {
+		log.Panic("ERROR: Miner address is not valid")
+	}
+
+	fee, err := bc.transactionFee(tx)
+	if err != nil {
+		log.Panic("ERROR: Can't mine the transaction: ", err)
+	}
+
+	cbTx := newCoinbaseTX(minerAddress, "", fee)
+	txs := []*Transaction{cbTx, tx}
+
+	_, err = bc.mineBlock(txs)
+	if err != nil {
+		log.Panic("ERROR: Can't mine the transaction: ", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) signMultisigTx(in string, address string, hashType sigHashType, nodeID string) {
+	wallets, err := newWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if wallets.Wallets[address] == nil {
+		log.Panic("ERROR: Address is not in the wallet file")
+	}
+	wallet := wallets.getWallet(address)
+
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	tx := loadTransactionFile(in)
+	signed := bc.signMultisigTransaction(&tx, &wallet, hashType)
+	if signed == 0 {
+		log.Panic("ERROR: Address is not a signer of any input")
+	}
+	saveTransactionFile(in, &tx)
+
+	if bc.verifyTransaction(&tx) {
+		fmt.Printf("Signed %d inputs, transaction is complete\n", signed)
+	} else {
+		fmt.Printf("Signed %d inputs, more signatures are required\n", signed)
+	}
+}
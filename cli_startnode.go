@@ -5,7 +5,7 @@ import (
 	"log"
 )
 
-func (cli *CLI) startNode(nodeID, minerAddress string) {
+func (cli *CLI) startNode(nodeID, minerAddress string, indexes, dropIndexes []chainIndex, pruneDepth int) {
 	fmt.Printf("Starting node %s\n", nodeID)
 	if len(minerAddress) > 0 {
 		if validateAddress(minerAddress) {
@@ -14,5 +14,5 @@ func (cli *CLI) startNode(nodeID, minerAddress string) {
 			log.Panic("Wrong miner address!")
 		}
 	}
-	startServer(nodeID, minerAddress)
+	startServer(nodeID, minerAddress, indexes, dropIndexes, pruneDepth)
 }
\ No newline at end of file
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func (cli *CLI) verifyAnchor(dataHex string, file string, height int, nodeID string) {
+	data := anchorPayload(dataHex, file)
+
+	bc := newBlockchain(nodeID)
+	defer bc.db.Close()
+
+	anchor, err := bc.findAnchor(data, height)
+	if err != nil {
+		fmt.Printf("%x is not anchored at height %d: %s\n", data, height, err)
+		os.Exit(1)
+	}
+
+	root := anchor.Block.hashTransactions()
+	pow := newPow(anchor.Block)
+
+	fmt.Printf("Data: %x\n", data)
+	fmt.Printf("Block: %x (height %d, %d confirmations)\n", anchor.Block.Hash, anchor.Block.Height, bc.getBestHeight()-anchor.Block.Height+1)
+	fmt.Printf("Transaction: %x output %d\n", anchor.TX.ID, anchor.Output)
+	fmt.Printf("Merkle root: %x\n", root)
+	fmt.Printf("Merkle branch (index %d):\n", anchor.Proof.Index)
+	for _, sibling := range anchor.Proof.Siblings {
+		fmt.Printf("  %x\n", sibling)
+	}
+
+	if root == nil || !anchor.Proof.verify(anchor.TX.ID, root) || !pow.validate() {
+		fmt.Println("Proof: INVALID, the block does not commit to its transactions")
+		os.Exit(1)
+	}
+	fmt.Println("Proof: valid")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) verifyChain(depth, level int, nodeID string) {
+	bc := newBlockchain(nodeID)
+
+	result, err := bc.verifyChain(depth, level)
+	bc.db.Close()
+
+	var bad *inconsistentBlock
+	if errors.As(err, &bad) {
+		fmt.Printf("The first inconsistent block is %x at height %d: %s\n", bad.Hash, bad.Height, bad.Reason)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic("ERROR: Can't verify the chain: ", err)
+	}
+
+	fmt.Printf("Checked %d blocks at level %d, no problems found\n", result.Blocks, level)
+	if result.Pruned > 0 {
+		fmt.Printf("%d of them are pruned, only their headers were checked\n", result.Pruned)
+	}
+	if result.Skipped > 0 {
+		fmt.Printf("%d of them are below the undo data the UTXO set is rolled back with, their transactions were not checked\n", result.Skipped)
+	}
+	if level >= verifyUTXOSet {
+		fmt.Printf("The UTXO set rebuilt from the blocks matches the stored one: %d outputs, hash %x\n", result.UTXOs, result.Hash)
+	}
+}
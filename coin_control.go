@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+)
+
+const lockedBucket = "lockedunspent" // outputs reserved by the wallet, skipped by automatic coin selection
+
+// outpoint references output Vout of transaction TxID
+type outpoint struct {
+	TxID []byte
+	Vout int
+}
+
+func (o outpoint) key() []byte {
+	key := make([]byte, len(o.TxID)+4)
+	copy(key, o.TxID)
+	binary.BigEndian.PutUint32(key[len(o.TxID):], uint32(o.Vout))
+
+	return key
+}
+
+// outpointFromKey is the inverse of key
+func outpointFromKey(key []byte) outpoint {
+	split := len(key) - 4
+
+	return outpoint{append([]byte{}, key[:split]...), int(binary.BigEndian.Uint32(key[split:]))}
+}
+
+func (o outpoint) String() string {
+	return fmt.Sprintf("%x:%d", o.TxID, o.Vout)
+}
+
+// parseOutpoints parses a comma separated list of txid:vout
+func parseOutpoints(list string) ([]outpoint, error) {
+	var outpoints []outpoint
+
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q is not txid:vout", item)
+		}
+
+		txID, err := hex.DecodeString(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%q has an invalid txid", item)
+		}
+
+		vout, err := strconv.Atoi(parts[1])
+		if err != nil || vout < 0 {
+			return nil, fmt.Errorf("%q has an invalid output index", item)
+		}
+
+		outpoints = append(outpoints, outpoint{txID, vout})
+	}
+
+	return outpoints, nil
+}
+
+// setLocked locks or unlocks outpoints for automatic coin selection. The
+// records of locked outpoints spent since are dropped on the way
+func (u UTXOSet) setLocked(outpoints []outpoint, locked bool) {
+	err := u.blockchain.db.Update(func(tx StoreTx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(lockedBucket))
+		if err != nil {
+			return err
+		}
+
+		utxos := tx.Bucket([]byte(utxoBucket))
+		var spent [][]byte
+		err = bucket.ForEach(func(k, v []byte) error {
+			if utxos.Get(k) == nil {
+				spent = append(spent, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range spent {
+			err = bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, o := range outpoints {
+			if locked {
+				err = bucket.Put(o.key(), []byte{1})
+			} else {
+				err = bucket.Delete(o.key())
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// isUnspent reports whether o is in the UTXO set
+func (u UTXOSet) isUnspent(o outpoint) bool {
+	unspent := false
+
+	err := u.blockchain.db.View(func(tx StoreTx) error {
+		unspent = tx.Bucket([]byte(utxoBucket)).Get(o.key()) != nil
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return unspent
+}
+
+// lockedOutpoints returns the keys of the locked outpoints still unspent
+func (u UTXOSet) lockedOutpoints() map[string]bool {
+	locked := make(map[string]bool)
+
+	err := u.blockchain.db.View(func(tx StoreTx) error {
+		bucket := tx.Bucket([]byte(lockedBucket))
+		if bucket == nil {
+			return nil
+		}
+
+		utxos := tx.Bucket([]byte(utxoBucket))
+		return bucket.ForEach(func(k, v []byte) error {
+			if utxos.Get(k) != nil {
+				locked[string(k)] = true
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return locked
+}
+
+// getSpendable returns the unspent output at o if it is locked with pubKeyHash
+func (u UTXOSet) getSpendable(o outpoint, pubKeyHash []byte) (spendableOutput, error) {
+	for _, candidate := range u.listUnspent(pubKeyHash) {
+		if candidate.Vout == o.Vout && hex.EncodeToString(candidate.TxID) == hex.EncodeToString(o.TxID) {
+			return candidate, nil
+		}
+	}
+
+	return spendableOutput{}, errors.New("output " + o.String() + " is not an unspent output of the wallet")
+}
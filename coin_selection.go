@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+const bnbMaxTries = 100000
+
+var errInsufficientFunds = errors.New("Not enough funds")
+
+// spendableOutput is an unspent output the wallet can use as an input
+type spendableOutput struct {
+	TxID   []byte
+	Vout   int
+	Value  int
+	Height int // of the block that created the output
+}
+
+// coinSelector picks the outputs funding a transaction of target value
+type coinSelector interface {
+	selectCoins(candidates []spendableOutput, target int) ([]spendableOutput, error)
+}
+
+var coinSelectors = map[string]coinSelector{
+	"bnb":      branchAndBoundSelector{},
+	"largest":  largestFirstSelector{},
+	"smallest": smallestFirstSelector{},
+	"privacy":  privacySelector{},
+}
+
+const defaultCoinSelector = "bnb"
+
+func getCoinSelector(name string) (coinSelector, error) {
+	selector, ok := coinSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown coin selection strategy %s", name)
+	}
+
+	return selector, nil
+}
+
+// accumulate takes candidates in order until target is reached
+func accumulate(candidates []spendableOutput, target int) ([]spendableOutput, error) {
+	var selected []spendableOutput
+	total := 0
+
+	for _, candidate := range candidates {
+		if total >= target {
+			break
+		}
+		selected = append(selected, candidate)
+		total += candidate.Value
+	}
+
+	if total < target {
+		return nil, errInsufficientFunds
+	}
+
+	return selected, nil
+}
+
+func sortByValue(candidates []spendableOutput, descending bool) []spendableOutput {
+	sorted := append([]spendableOutput{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if descending {
+			return sorted[i].Value > sorted[j].Value
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+
+	return sorted
+}
+
+// largestFirstSelector spends the fewest outputs possible
+type largestFirstSelector struct{}
+
+func (largestFirstSelector) selectCoins(candidates []spendableOutput, target int) ([]spendableOutput, error) {
+	return accumulate(sortByValue(candidates, true), target)
+}
+
+// smallestFirstSelector consolidates small outputs
+type smallestFirstSelector struct{}
+
+func (smallestFirstSelector) selectCoins(candidates []spendableOutput, target int) ([]spendableOutput, error) {
+	return accumulate(sortByValue(candidates, false), target)
+}
+
+// branchAndBoundSelector searches for a set of outputs matching target
+// exactly so no change output is needed. When no exact match exists it
+// falls back to largest first
+type branchAndBoundSelector struct{}
+
+func (branchAndBoundSelector) selectCoins(candidates []spendableOutput, target int) ([]spendableOutput, error) {
+	total := 0
+	for _, candidate := range candidates {
+		total += candidate.Value
+	}
+	if total < target {
+		return nil, errInsufficientFunds
+	}
+
+	selected, ok := exactMatch(candidates, target)
+	if !ok {
+		return largestFirstSelector{}.selectCoins(candidates, target)
+	}
+
+	return selected, nil
+}
+
+// exactMatch searches, within bnbMaxTries, for a set of candidates worth
+// exactly target
+func exactMatch(candidates []spendableOutput, target int) ([]spendableOutput, bool) {
+	sorted := sortByValue(candidates, true)
+
+	remaining := make([]int, len(sorted)+1) // remaining[i] is the value of sorted[i:]
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value
+	}
+
+	var chosen []int
+	tries := 0
+
+	var search func(index int, total int) bool
+	search = func(index int, total int) bool {
+		tries++
+		if total == target {
+			return true
+		}
+		if index == len(sorted) || total > target || total+remaining[index] < target || tries > bnbMaxTries {
+			return false
+		}
+
+		chosen = append(chosen, index)
+		if search(index+1, total+sorted[index].Value) {
+			return true
+		}
+		chosen = chosen[:len(chosen)-1]
+
+		return search(index+1, total)
+	}
+
+	if !search(0, 0) {
+		return nil, false
+	}
+
+	var selected []spendableOutput
+	for _, index := range chosen {
+		selected = append(selected, sorted[index])
+	}
+
+	return selected, true
+}
+
+// privacySelector avoids linking outputs: it spends the smallest single
+// output covering target and otherwise accumulates in random order so the
+// change amount isn't predictable from the wallet contents
+type privacySelector struct{}
+
+func (privacySelector) selectCoins(candidates []spendableOutput, target int) ([]spendableOutput, error) {
+	for _, candidate := range sortByValue(candidates, false) {
+		if candidate.Value >= target {
+			return []spendableOutput{candidate}, nil
+		}
+	}
+
+	shuffled := append([]spendableOutput{}, candidates...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	return accumulate(shuffled, target)
+}
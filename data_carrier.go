@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+)
+
+const maxDataCarrierSize = 80 // maximum payload of a data-carrier output
+
+// newDataOutput creates a provably unspendable, zero value output carrying data
+func newDataOutput(data []byte) (*TXOutput, error) {
+	if len(data) == 0 || len(data) > maxDataCarrierSize {
+		return nil, errors.New("data must be between 1 and 80 bytes")
+	}
+
+	return &TXOutput{Value: 0, ScriptType: scriptData, Data: data}, nil
+}
+
+// isUnspendable reports whether the output can never be spent and is kept out of the UTXO set
+func (out *TXOutput) isUnspendable() bool {
+	return out.ScriptType == scriptData
+}
+
+// checkDataOutputs enforces the zero value and size limit of data-carrier outputs
+func (tx *Transaction) checkDataOutputs() bool {
+	for _, out := range tx.Vout {
+		if out.ScriptType != scriptData {
+			continue
+		}
+		if out.Value != 0 || len(out.Data) == 0 || len(out.Data) > maxDataCarrierSize {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newAnchorTransaction commits data to the chain. It spends the wallet's
+// outputs like a payment, with the data-carrier output first and the
+// change back to the wallet
+func newAnchorTransaction(wallet *Wallet, data []byte, opts txOptions, UTXOSet *UTXOSet) *Transaction {
+	dataOutput, err := newDataOutput(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	plan := planSpend(hashPubKey(wallet.PublicKey), []TXOutput{*dataOutput}, nil, opts, UTXOSet)
+
+	return newPaymentTransaction(wallet, plan, opts, UTXOSet)
+}
+
+// anchorProof shows that data was committed by a transaction of a block
+type anchorProof struct {
+	Block  *block
+	TX     *Transaction
+	Output int
+	Proof  merkleProof
+}
+
+// findAnchor looks for a data-carrier output with data in the block at height
+func (bc *blockchain) findAnchor(data []byte, height int) (*anchorProof, error) {
+	block, err := bc.getBlockAtHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	for txIndex, tx := range block.Transactions {
+		for outIdx, out := range tx.Vout {
+			if out.ScriptType == scriptData && bytes.Equal(out.Data, data) {
+				proof := newMerkleProof(block.transactionIDs(), txIndex)
+				return &anchorProof{block, tx, outIdx, proof}, nil
+			}
+		}
+	}
+
+	return nil, errors.New("data is not committed in this block")
+}
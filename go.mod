@@ -2,10 +2,12 @@ module blockchain
 
 go 1.22.4
 
-require golang.org/x/crypto v0.24.0
+require (
+	github.com/boltdb/bolt v1.3.1
+	golang.org/x/crypto v0.24.0
+)
 
 require (
-	github.com/boltdb/bolt v1.3.1 // indirect
 	github.com/jackpal/bencode-go v0.0.0-20180813173944-227668e840fa // indirect
 	github.com/veggiedefender/torrent-client v0.0.0-20230215201203-e0f58e0b16e4 // indirect
 	golang.org/x/sys v0.21.0 // indirect
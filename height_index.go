@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const heightIndexBucket = "heightindex"
+
+// heightIndex maps the height of every block of the active chain to its
+// hash. Unlike the other indexes it is always enabled; chains created
+// before it existed have it built the next time the node starts
+type heightIndex struct{}
+
+func (heightIndex) name() string {
+	return heightIndexBucket
+}
+
+func (heightIndex) connect(tx StoreTx, b *block) error {
+	return tx.Bucket([]byte(heightIndexBucket)).Put(heightKey(b.Height), b.Hash)
+}
+
+func (heightIndex) disconnect(tx StoreTx, b *block) error {
+	return tx.Bucket([]byte(heightIndexBucket)).Delete(heightKey(b.Height))
+}
+
+func heightKey(height int) []byte {
+	var key [4]byte
+	binary.BigEndian.PutUint32(key[:], uint32(height))
+
+	return key[:]
+}
+
+var errHeightIndexUnavailable = errors.New("the height index is still being built")
+
+// lookupBlockHashes returns the hashes of the active chain from height from
+// to height to, both included, oldest first
+func (bc *blockchain) lookupBlockHashes(from, to int) ([][]byte, error) {
+	var hashes [][]byte
+
+	err := bc.db.View(func(tx StoreTx) error {
+		if !bc.indexSynced(tx, heightIndex{}) {
+			return errHeightIndexUnavailable
+		}
+
+		cursor := tx.Bucket([]byte(heightIndexBucket)).Cursor()
+		for k, v := cursor.Seek(heightKey(from)); k != nil && int(binary.BigEndian.Uint32(k)) <= to; k, v = cursor.Next() {
+			hashes = append(hashes, append([]byte{}, v...))
+		}
+
+		return nil
+	})
+
+	return hashes, err
+}
+
+// getBlockHashesInRange returns the hashes of the active chain from height
+// from to height to, both included, oldest first. Without the height index
+// the chain is walked from the tip
+func (bc *blockchain) getBlockHashesInRange(from, to int) [][]byte {
+	if from < 0 {
+		from = 0
+	}
+	if to < from {
+		return nil
+	}
+
+	hashes, err := bc.lookupBlockHashes(from, to)
+	if err == nil {
+		return hashes
+	}
+
+	bci := bc.iterator()
+	for {
+		block := bci.next()
+
+		if block.Height >= from && block.Height <= to {
+			hashes = append([][]byte{block.Hash}, hashes...)
+		}
+
+		if len(block.PrevBlockHash) == 0 || block.Height <= from {
+			break
+		}
+	}
+
+	return hashes
+}
+
+// getBlockHashAtHeight returns the hash of the block of the active chain at height
+func (bc *blockchain) getBlockHashAtHeight(height int) ([]byte, error) {
+	hashes := bc.getBlockHashesInRange(height, height)
+	if len(hashes) == 0 {
+		return nil, errors.New("Block is not found")
+	}
+
+	return hashes[0], nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log"
+)
+
+const secretSize = 32
+const htlcContractSize = sha256.Size + 20 + 20 + 8
+
+// htlcContract is the redeem script of a hash time-locked output. The
+// recipient can spend it by revealing the secret, the refund key can spend
+// it once the lock time has passed.
+type htlcContract struct {
+	SecretHash          []byte
+	RecipientPubKeyHash []byte
+	RefundPubKeyHash    []byte
+	LockTime            int64
+	RecipientVersion    byte // address versions of the parties, see keyType.addressVersion
+	RefundVersion       byte
+}
+
+func newHTLCContract(secretHash []byte, recipient string, refund string, lockTime int64) (*htlcContract, error) {
+	if len(secretHash) != sha256.Size {
+		return nil, errors.New("secret hash must be a SHA-256 hash")
+	}
+
+	if lockTime <= 0 {
+		return nil, errors.New("lock time must be positive")
+	}
+
+	recipientVersion, recipientHash := decodeAddress([]byte(recipient))
+	refundVersion, refundHash := decodeAddress([]byte(refund))
+	if !isKeyAddressVersion(recipientVersion) || !isKeyAddressVersion(refundVersion) {
+		return nil, errors.New("contract parties must be single key addresses")
+	}
+
+	return &htlcContract{secretHash, recipientHash, refundHash, lockTime, recipientVersion, refundVersion}, nil
+}
+
+// serialize encodes the contract as secret hash || recipient || refund ||
+// lock time, followed by the recipient and refund address versions unless
+// both are ECDSA, which keeps the encoding of the first contracts
+func (c htlcContract) serialize() []byte {
+	var buf bytes.Buffer
+
+	buf.Write(c.SecretHash)
+	buf.Write(c.RecipientPubKeyHash)
+	buf.Write(c.RefundPubKeyHash)
+	err := binary.Write(&buf, binary.BigEndian, c.LockTime)
+	if err != nil {
+		log.Panic(err)
+	}
+	if c.RecipientVersion != version || c.RefundVersion != version {
+		buf.Write([]byte{c.RecipientVersion, c.RefundVersion})
+	}
+
+	return buf.Bytes()
+}
+
+func deserializeHTLCContract(data []byte) (*htlcContract, error) {
+	if len(data) != htlcContractSize && len(data) != htlcContractSize+2 {
+		return nil, errors.New("contract has an invalid length")
+	}
+
+	contract := htlcContract{
+		SecretHash:          data[:32],
+		RecipientPubKeyHash: data[32:52],
+		RefundPubKeyHash:    data[52:72],
+		LockTime:            int64(binary.BigEndian.Uint64(data[72:80])),
+		RecipientVersion:    version,
+		RefundVersion:       version,
+	}
+
+	if len(data) > htlcContractSize {
+		contract.RecipientVersion, contract.RefundVersion = data[80], data[81]
+		if !isKeyAddressVersion(contract.RecipientVersion) || !isKeyAddressVersion(contract.RefundVersion) {
+			return nil, errors.New("contract has an invalid address version")
+		}
+		if contract.RecipientVersion == version && contract.RefundVersion == version {
+			return nil, errors.New("contract has a non-canonical encoding")
+		}
+	}
+
+	return &contract, nil
+}
+
+func (c htlcContract) hash() []byte {
+	return hashPubKey(c.serialize())
+}
+
+func newSecret() ([]byte, []byte) {
+	secret := make([]byte, secretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		log.Panic(err)
+	}
+	secretHash := sha256.Sum256(secret)
+
+	return secret, secretHash[:]
+}
+
+// verifyHTLCInput checks the redeem path (secret and recipient signature)
+// or the refund path (refund signature and a lock time at or past the contract's)
+func verifyHTLCInput(tx *Transaction, vin TXInput, prevOut TXOutput, checkSig func(pubKey, signature []byte) bool) bool {
+	if !bytes.Equal(hashPubKey(vin.RedeemScript), prevOut.PubKeyHash) {
+		return false
+	}
+
+	contract, err := deserializeHTLCContract(vin.RedeemScript)
+	if err != nil {
+		return false
+	}
+
+	if len(vin.Preimage) > 0 {
+		secretHash := sha256.Sum256(vin.Preimage)
+		if !bytes.Equal(secretHash[:], contract.SecretHash) || !vin.usesKey(contract.RecipientPubKeyHash) {
+			return false
+		}
+	} else {
+		if !vin.usesKey(contract.RefundPubKeyHash) {
+			return false
+		}
+
+		sameKind := (tx.LockTime < lockTimeThreshold) == (contract.LockTime < lockTimeThreshold)
+		if !sameKind || tx.LockTime < contract.LockTime {
+			return false
+		}
+	}
+
+	return checkSig(vin.PubKey, vin.Signature)
+}
+
+// newHTLCTransaction locks amount from wallet into contract, the contract is output 0
+func newHTLCTransaction(wallet *Wallet, contract *htlcContract, amount int, opts txOptions, UTXOSet *UTXOSet) *Transaction {
+	contractOutput := TXOutput{amount, contract.hash(), scriptHTLC, nil}
+	plan := planSpend(hashPubKey(wallet.PublicKey), []TXOutput{contractOutput}, nil, opts, UTXOSet)
+
+	return newPaymentTransaction(wallet, plan, opts, UTXOSet)
+}
+
+// newHTLCSpendTransaction spends the contract output at contractTx:vout to
+// wallet, revealing secret to redeem or, with a nil secret, refunding it
+func newHTLCSpendTransaction(wallet *Wallet, contract *htlcContract, contractTx *Transaction, vout int, secret []byte, bc *blockchain) *Transaction {
+	input := TXInput{
+		Txid:         contractTx.ID,
+		Vout:         vout,
+		PubKey:       wallet.PublicKey,
+		RedeemScript: contract.serialize(),
+		Preimage:     secret,
+	}
+	output := newTXOutput(contractTx.Vout[vout].Value, string(wallet.getAddress()))
+
+	tx := Transaction{Vin: []TXInput{input}, Vout: []TXOutput{*output}}
+	if secret == nil {
+		tx.LockTime = contract.LockTime
+	}
+	tx.setTXID()
+	bc.signTransaction(&tx, wallet, sigHashAll)
+
+	return &tx
+}
+
+// findContractOutput returns the index of the output of tx locked to contract
+func findContractOutput(tx *Transaction, contract *htlcContract) (int, error) {
+	contractHash := contract.hash()
+
+	for i, out := range tx.Vout {
+		if out.ScriptType == scriptHTLC && bytes.Equal(out.PubKeyHash, contractHash) {
+			return i, nil
+		}
+	}
+
+	return -1, errors.New("transaction does not pay to the contract")
+}
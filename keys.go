@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"log"
+)
+
+// keyType is the signature scheme of a wallet key
+type keyType byte
+
+const (
+	keyECDSA   keyType = iota // ECDSA over P-256
+	keyEd25519                // Ed25519
+)
+
+const ed25519Version = byte(0x0e)   // address version for Ed25519 public key hashes
+const ed25519KeyPrefix = byte(0xed) // first byte of an encoded Ed25519 public key
+const ed25519PubKeyLen = 1 + ed25519.PublicKeySize
+
+var keyTypeNames = map[keyType]string{
+	keyECDSA:   "ecdsa",
+	keyEd25519: "ed25519",
+}
+
+func (t keyType) String() string {
+	return keyTypeNames[t]
+}
+
+// addressVersion returns the version byte of addresses of keys of this type
+func (t keyType) addressVersion() byte {
+	if t == keyEd25519 {
+		return ed25519Version
+	}
+
+	return version
+}
+
+// isKeyAddressVersion reports whether v is the address version of a key type
+func isKeyAddressVersion(v byte) bool {
+	for t := range keyTypeNames {
+		if t.addressVersion() == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseKeyType(name string) (keyType, error) {
+	for t, n := range keyTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown key type %q", name)
+}
+
+// pubKeyType tells the scheme of an encoded public key. Ed25519 keys carry
+// a prefix byte no SEC1 or legacy P-256 key of that length starts with, so
+// the hash of a public key also commits to its type
+func pubKeyType(pubKey []byte) keyType {
+	if len(pubKey) == ed25519PubKeyLen && pubKey[0] == ed25519KeyPrefix {
+		return keyEd25519
+	}
+
+	return keyECDSA
+}
+
+func newEd25519KeyPair() (ed25519.PrivateKey, []byte) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return private, append([]byte{ed25519KeyPrefix}, public...)
+}
+
+// verifyEd25519 checks an Ed25519 signature of data against an encoded public key
+func verifyEd25519(pubKey []byte, data []byte, signature []byte) bool {
+	if len(signature) != ed25519.SignatureSize {
+		return false
+	}
+
+	return ed25519.Verify(ed25519.PublicKey(pubKey[1:]), data, signature)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+// acceptToMempool checks tx against the consensus rules for the next block
+// and the relay policy before adding it to the mempool
+func acceptToMempool(tx *Transaction, bc *blockchain) error {
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := mempool[txID]; ok {
+		return reject("txn-already-in-mempool", "transaction is already in the mempool")
+	}
+
+	if tx.isCoinbase() {
+		return reject("coinbase", "coinbase transactions are only valid in blocks")
+	}
+
+	err := standardPolicy.checkStandard(tx)
+	if err != nil {
+		return err
+	}
+
+	err = bc.validateTransaction(tx, bc.getBestHeight()+1, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	for _, vin := range tx.Vin {
+		for id, memTx := range mempool {
+			for _, memVin := range memTx.Vin {
+				if memVin.Vout == vin.Vout && hex.EncodeToString(memVin.Txid) == hex.EncodeToString(vin.Txid) {
+					return reject("txn-mempool-conflict", "output %x:%d is already spent by %s", vin.Txid, vin.Vout, id)
+				}
+			}
+		}
+	}
+
+	mempool[txID] = *tx
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 )
 
@@ -17,21 +18,21 @@ type MerkleNode struct {
 func newMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []MerkleNode
 
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
-	}
-
 	for _, datum := range data {
-		node := MerkleNode{nil, nil, datum}
-		nodes = append(nodes, node)
+		node := newMerkleNode(nil, nil, datum)
+		nodes = append(nodes, *node)
 	}
 
-	for i := 0; i < len(data)/2; i++ {
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1]) // duplicate the last node of odd levels
+		}
+
 		var newLevel []MerkleNode
 
 		for j := 0; j < len(nodes); j += 2 {
-			node := MerkleNode{&nodes[j], &nodes[j+1], nil}
-			newLevel = append(newLevel, node)
+			node := newMerkleNode(&nodes[j], &nodes[j+1], nil)
+			newLevel = append(newLevel, *node)
 		}
 
 		nodes = newLevel
@@ -43,12 +44,76 @@ func newMerkleTree(data [][]byte) *MerkleTree {
 }
 
 func newMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
-	node := MerkleNode{left, right, data}
+	node := MerkleNode{}
 
-	if left != nil && right != nil {
-		hash := sha256.Sum256(append(left.Data, right.Data...))
+	if left == nil && right == nil {
+		hash := sha256.Sum256(data)
+		node.Data = hash[:]
+	} else {
+		prevHashes := append(append([]byte{}, left.Data...), right.Data...)
+		hash := sha256.Sum256(prevHashes)
 		node.Data = hash[:]
 	}
 
+	node.Left = left
+	node.Right = right
+
 	return &node
-}
\ No newline at end of file
+}
+
+// merkleProof lists the sibling hashes from a leaf up to the root
+type merkleProof struct {
+	Index    int
+	Siblings [][]byte
+}
+
+// newMerkleProof builds the inclusion proof of data[index]
+func newMerkleProof(data [][]byte, index int) merkleProof {
+	var level [][]byte
+	proof := merkleProof{Index: index}
+
+	for _, datum := range data {
+		hash := sha256.Sum256(datum)
+		level = append(level, hash[:])
+	}
+
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+
+		proof.Siblings = append(proof.Siblings, level[index^1])
+
+		var newLevel [][]byte
+		for j := 0; j < len(level); j += 2 {
+			hash := sha256.Sum256(append(append([]byte{}, level[j]...), level[j+1]...))
+			newLevel = append(newLevel, hash[:])
+		}
+
+		level = newLevel
+		index /= 2
+	}
+
+	return proof
+}
+
+// verify checks that datum hashes up to root through the proof
+func (p merkleProof) verify(datum []byte, root []byte) bool {
+	hash := sha256.Sum256(datum)
+	current := hash[:]
+	index := p.Index
+
+	for _, sibling := range p.Siblings {
+		var combined []byte
+		if index%2 == 0 {
+			combined = append(append(combined, current...), sibling...)
+		} else {
+			combined = append(append(combined, sibling...), current...)
+		}
+		hash = sha256.Sum256(combined)
+		current = hash[:]
+		index /= 2
+	}
+
+	return bytes.Equal(current, root)
+}
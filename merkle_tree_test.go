@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMerkleRootRepeatedLastLeaf(t *testing.T) {
+	data := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	repeated := append(append([][]byte{}, data...), []byte("c"))
+
+	if !bytes.Equal(newMerkleTree(data).RootNode.Data, newMerkleTree(repeated).RootNode.Data) {
+		t.Fatal("repeating the last leaf of an odd level changes the root, the duplicate transaction rule may no longer be needed")
+	}
+}
+
+func TestDuplicateTransactionBlock(t *testing.T) {
+	miner := newWallet(keyECDSA)
+	recipient := newWallet(keyECDSA)
+	minerAddress := string(miner.getAddress())
+	recipientAddress := string(recipient.getAddress())
+
+	// the genesis reward is split so both wallets can pay in the next block
+	coinbase := newCoinbaseTX(minerAddress, "genesis", 0)
+	coinbase.Vout[0].Value = reward / 2
+	coinbase.Vout = append(coinbase.Vout, *newTXOutput(reward/2, recipientAddress))
+	coinbase.setTXID()
+
+	genesis := genesisBlock(coinbase)
+	bc := initBlockchainWithGenesis(newMemoryStore(), genesis)
+	UTXOSet := UTXOSet{bc}
+
+	opts := txOptions{Selector: coinSelectors[defaultCoinSelector]}
+	paid := newPaymentTransaction(miner, planPayment(miner, []payment{{recipientAddress, 30}}, opts, &UTXOSet), opts, &UTXOSet)
+	refund := newPaymentTransaction(recipient, planPayment(recipient, []payment{{minerAddress, 20}}, opts, &UTXOSet), opts, &UTXOSet)
+
+	// three transactions, so the last one is paired with itself in the Merkle tree
+	valid := newBlock(genesis.Timestamp+1, []*Transaction{newCoinbaseTX(minerAddress, "", 0), paid, refund}, genesis.Hash, 1)
+
+	mutated := *valid
+	mutated.Transactions = append(append([]*Transaction{}, valid.Transactions...), refund)
+	if !newPow(&mutated).validateHash() {
+		t.Fatal("the block with the last transaction repeated doesn't have the hash of the valid one")
+	}
+
+	var rejected *rejectError
+	err := bc.addBlock(&mutated)
+	if !errors.As(err, &rejected) || rejected.Code != "bad-txns-duplicate" {
+		t.Fatalf("the block with the last transaction repeated is not refused as a duplicate: %v", err)
+	}
+
+	err = bc.addBlock(valid)
+	if err != nil {
+		t.Fatalf("the valid block is refused after the duplicate: %s", err)
+	}
+	if height := bc.getBestHeight(); height != 1 {
+		t.Errorf("the best height is %d, not 1", height)
+	}
+}
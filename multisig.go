@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const scriptHashVersion = byte(0x05) // address version for outputs locked to a redeem script
+const maxMultisigKeys = 16
+
+// multisigScript is the redeem script of an M-of-N multisignature output.
+// The output only stores the hash of the serialized script, the spending
+// input reveals the script and one signature slot per public key.
+type multisigScript struct {
+	Required int
+	PubKeys  [][]byte
+}
+
+func newMultisigScript(required int, pubKeys [][]byte) (*multisigScript, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > maxMultisigKeys {
+		return nil, fmt.Errorf("number of public keys must be between 1 and %d", maxMultisigKeys)
+	}
+
+	if required < 1 || required > len(pubKeys) {
+		return nil, fmt.Errorf("required signatures must be between 1 and %d", len(pubKeys))
+	}
+
+	for i, pubKey := range pubKeys {
+		if len(pubKey) == 0 || len(pubKey) > 255 {
+			return nil, fmt.Errorf("public key %d has an invalid length", i)
+		}
+		for j := 0; j < i; j++ {
+			if bytes.Equal(pubKeys[j], pubKey) {
+				return nil, fmt.Errorf("public key %d is the same as public key %d", i, j)
+			}
+		}
+	}
+
+	return &multisigScript{required, pubKeys}, nil
+}
+
+// serialize encodes the script as M || N || (len || pubkey)*N so the
+// script hash does not depend on the gob encoder
+func (ms multisigScript) serialize() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteByte(byte(ms.Required))
+	buf.WriteByte(byte(len(ms.PubKeys)))
+	for _, pubKey := range ms.PubKeys {
+		buf.WriteByte(byte(len(pubKey)))
+		buf.Write(pubKey)
+	}
+
+	return buf.Bytes()
+}
+
+func deserializeMultisigScript(data []byte) (*multisigScript, error) {
+	if len(data) < 2 {
+		return nil, errors.New("redeem script is too short")
+	}
+
+	required := int(data[0])
+	count := int(data[1])
+	data = data[2:]
+
+	var pubKeys [][]byte
+	for i := 0; i < count; i++ {
+		if len(data) == 0 || len(data) < int(data[0])+1 {
+			return nil, errors.New("redeem script is truncated")
+		}
+		keyLen := int(data[0])
+		pubKeys = append(pubKeys, data[1:keyLen+1])
+		data = data[keyLen+1:]
+	}
+
+	if len(data) != 0 {
+		return nil, errors.New("redeem script has trailing data")
+	}
+
+	return newMultisigScript(required, pubKeys)
+}
+
+func (ms multisigScript) hash() []byte {
+	return hashPubKey(ms.serialize())
+}
+
+func (ms multisigScript) getAddress() []byte {
+	return encodeAddress(scriptHashVersion, ms.hash())
+}
+
+// keyIndex returns the signature slot of pubKey or -1 if it is not part of the script
+func (ms multisigScript) keyIndex(pubKey []byte) int {
+	for i, key := range ms.PubKeys {
+		if bytes.Equal(key, pubKey) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func verifyMultisigInput(vin TXInput, prevOut TXOutput, checkSig func(pubKey, signature []byte) bool) bool {
+	if !bytes.Equal(hashPubKey(vin.RedeemScript), prevOut.PubKeyHash) {
+		return false
+	}
+
+	script, err := deserializeMultisigScript(vin.RedeemScript)
+	if err != nil || len(vin.Signatures) != len(script.PubKeys) {
+		return false
+	}
+
+	valid := 0
+	for i, signature := range vin.Signatures {
+		if len(signature) == 0 {
+			continue // slot not signed yet
+		}
+		if !checkSig(script.PubKeys[i], signature) {
+			return false
+		}
+		valid++
+	}
+
+	return valid >= script.Required
+}
+
+// signMultisig fills the signature slot of the wallet key in every multisig input
+// whose redeem script contains it and returns the number of inputs signed
+func (tx *Transaction) signMultisig(wallet *Wallet, prevTXs map[string]Transaction, hashType sigHashType) int {
+	signed := 0
+
+	for inID, vin := range tx.Vin {
+		prevTX := prevTXs[hex.EncodeToString(vin.Txid)]
+		if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			log.Panic(fmt.Errorf("input %d spends a missing output %x:%d", inID, vin.Txid, vin.Vout))
+		}
+
+		prevOut := prevTX.Vout[vin.Vout]
+		if prevOut.ScriptType != scriptHash {
+			continue
+		}
+
+		script, err := deserializeMultisigScript(vin.RedeemScript)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		slot := script.keyIndex(wallet.PublicKey)
+		if slot < 0 {
+			continue
+		}
+		if len(vin.Signatures) != len(script.PubKeys) {
+			log.Panic(fmt.Errorf("input %d has %d signature slots for %d public keys", inID, len(vin.Signatures), len(script.PubKeys)))
+		}
+
+		tx.Vin[inID].Signatures[slot] = tx.signInput(wallet, inID, prevOut, hashType)
+		signed++
+	}
+
+	return signed
+}
+
+// newMultisigTransaction builds an unsigned transaction spending outputs
+// locked to script, selected like those of a payment, the change goes back
+// to the multisig address
+func newMultisigTransaction(script *multisigScript, to string, amount int, opts txOptions, UTXOSet *UTXOSet) *Transaction {
+	var inputs []TXInput
+	var outputs []TXOutput
+
+	plan := planSpend(script.hash(), nil, []payment{{to, amount}}, opts, UTXOSet)
+
+	redeemScript := script.serialize()
+	for _, in := range plan.Inputs {
+		input := TXInput{
+			Txid:         in.TxID,
+			Vout:         in.Vout,
+			RedeemScript: redeemScript,
+			Signatures:   make([][]byte, len(script.PubKeys)),
+		}
+		inputs = append(inputs, input)
+	}
+
+	outputs = append(outputs, *newTXOutput(amount, to))
+
+	if plan.Change > 0 {
+		outputs = append(outputs, *newTXOutput(plan.Change, string(script.getAddress())))
+	}
+
+	tx := Transaction{Vin: inputs, Vout: outputs}
+	tx.setTXID()
+
+	return &tx
+}
@@ -0,0 +1,80 @@
+package main
+
+// relayPolicy holds the standardness rules a node applies before relaying
+// or mining a transaction. Unlike consensus rules they can differ between
+// nodes and a block with non-standard transactions is still valid
+type relayPolicy struct {
+	DustThreshold  int // outputs below this value cost more to spend than they are worth
+	MaxTxSize      int // bytes of the serialized transaction
+	MaxInputs      int
+	MaxOutputs     int
+	MaxDataOutputs int
+}
+
+var standardPolicy = relayPolicy{
+	DustThreshold:  2,
+	MaxTxSize:      100000,
+	MaxInputs:      1000,
+	MaxOutputs:     1000,
+	MaxDataOutputs: 1,
+}
+
+// checkStandard returns why tx is not standard under the policy or nil
+func (p relayPolicy) checkStandard(tx *Transaction) error {
+	// version 0 IDs can't be checked against the data, so they are only
+	// accepted in blocks for the transactions mined before version 1
+	if tx.Version < 1 || tx.Version > txVersion {
+		return reject("version", "transaction version %d is not relayed", tx.Version)
+	}
+
+	if size := len(tx.serialize()); size > p.MaxTxSize {
+		return reject("tx-size", "transaction is %d bytes, the limit is %d", size, p.MaxTxSize)
+	}
+
+	if len(tx.Vin) > p.MaxInputs {
+		return reject("too-many-inputs", "transaction has %d inputs, the limit is %d", len(tx.Vin), p.MaxInputs)
+	}
+
+	if len(tx.Vout) > p.MaxOutputs {
+		return reject("too-many-outputs", "transaction has %d outputs, the limit is %d", len(tx.Vout), p.MaxOutputs)
+	}
+
+	if !tx.isCoinbase() {
+		for i, vin := range tx.Vin {
+			if len(vin.Signature) > 0 && !isStandardSignature(vin.PubKey, vin.Signature) {
+				return reject("non-canonical-signature", "input %d has a signature that is not canonical with a valid sighash type", i)
+			}
+
+			if len(vin.Signatures) == 0 {
+				continue
+			}
+			script, err := deserializeMultisigScript(vin.RedeemScript)
+			if err != nil || len(script.PubKeys) != len(vin.Signatures) {
+				return reject("bad-redeem-script", "input %d has a malformed redeem script", i)
+			}
+			for j, signature := range vin.Signatures {
+				if len(signature) > 0 && !isStandardSignature(script.PubKeys[j], signature) {
+					return reject("non-canonical-signature", "input %d has a signature that is not canonical with a valid sighash type", i)
+				}
+			}
+		}
+	}
+
+	dataOutputs := 0
+	for i, out := range tx.Vout {
+		if out.isUnspendable() {
+			dataOutputs++
+			continue
+		}
+
+		if out.Value < p.DustThreshold {
+			return reject("dust", "output %d value %d is below the dust threshold of %d", i, out.Value, p.DustThreshold)
+		}
+	}
+
+	if dataOutputs > p.MaxDataOutputs {
+		return reject("multi-op-return", "transaction has %d data-carrier outputs, the limit is %d", dataOutputs, p.MaxDataOutputs)
+	}
+
+	return nil
+}
@@ -12,24 +12,42 @@ import (
 const targetBits = 24	
 
 type proofOfWork struct {
-	block  *block
-	target *big.Int
+	block       *block
+	target      *big.Int
+	commitments []byte // Merkle roots of the transactions, computed once instead of for every nonce
 }
 
 func newPow(b *block) *proofOfWork { 			// create a new proof of work struct
+	target := powTarget()
+	commitments := b.Commitments
+	if !b.Pruned {
+		commitments = append(b.hashTransactions(), b.hashWitnesses()...)
+	}
+	return &proofOfWork{b, target, commitments}
+}
+
+// powTarget returns the value block hashes must be below
+func powTarget() *big.Int {
 	target := big.NewInt(1)
 	target.Lsh(target, uint(256-targetBits))
-	return &proofOfWork{b, target}
+
+	return target
 }
 
 func (p *proofOfWork) prepareData(nonce int) []byte {	// prepare the data to be hashed
-	data := bytes.Join([][]byte{
+	fields := [][]byte{
 		p.block.PrevBlockHash,
-		p.block.hashTransactions(),
+		p.commitments,
 		[]byte(strconv.FormatInt(p.block.Timestamp, 10)),
 		[]byte(strconv.FormatInt(int64(targetBits), 10)),
 		[]byte(strconv.FormatInt(int64(nonce), 10)),
-	}, []byte{})	// concatenate the byte slices, data = "prevBlockHash + data + timestamp + targetBits + nonce"
+	}
+	if p.block.Version >= 2 {
+		// the version and height of older blocks aren't covered, so a peer could relabel them
+		fields = append(fields, []byte(strconv.Itoa(p.block.Version)), []byte(strconv.Itoa(p.block.Height)))
+	}
+
+	data := bytes.Join(fields, []byte{})	// concatenate the byte slices, data = "prevBlockHash + data + timestamp + targetBits + nonce"
 	return data
 }
 
@@ -56,6 +74,13 @@ func (p *proofOfWork) run() (int, []byte) {	// run the proof of work algorithm
 	return nonce, hash[:] 	//return the nonce and hash
 }
 
+// validateHash checks the proof of work and that it is the hash of the block
+func (p *proofOfWork) validateHash() bool {
+	hash := sha256.Sum256(p.prepareData(p.block.Nonce))
+
+	return bytes.Equal(hash[:], p.block.Hash) && p.validate()
+}
+
 func (p *proofOfWork) validate() bool {
 	var hashInt big.Int		// hashInt is a big.Int type to store the hash as an integer
 
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const pruneDepthKey = "prune"        // meta key of the number of blocks kept with their transactions, 0 if not pruning
+const pruneHeightKey = "pruneheight" // meta key of the lowest height whose block still has its transactions
+const minPruneDepth = 10             // blocks kept at least, the deepest reorganization a pruned node can follow
+const pruneBatch = 500               // blocks pruned per write transaction
+
+// cacheFlusher is implemented by stores holding writes in memory
+type cacheFlusher interface {
+	flushCache() error
+}
+
+// storeCompactor is implemented by stores that can give back the space of deleted data
+type storeCompactor interface {
+	compact() (int64, int64, error)
+}
+
+// pruneDepth returns how many of the latest blocks keep their transactions, 0 on an unpruned chain
+func (bc *blockchain) pruneDepth() int {
+	return bc.metaInt(pruneDepthKey)
+}
+
+// pruneHeight returns the lowest height whose block still has its
+// transactions. Below it only the headers are stored
+func (bc *blockchain) pruneHeight() int {
+	return bc.metaInt(pruneHeightKey)
+}
+
+func (bc *blockchain) metaInt(key string) int {
+	var value int
+
+	err := bc.db.View(func(tx StoreTx) error {
+		value = metaInt(tx, key)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return value
+}
+
+// setPruneDepth turns pruning on for the chain. The transaction and
+// address indexes need every block, so they can't be enabled with it
+func (bc *blockchain) setPruneDepth(depth int) error {
+	if depth < minPruneDepth {
+		return fmt.Errorf("pruning keeps at least the last %d blocks", minPruneDepth)
+	}
+
+	return bc.db.Update(func(tx StoreTx) error {
+		for _, index := range []chainIndex{txIndex{}, addrIndex{}} {
+			if _, enabled := indexTip(tx, index); enabled {
+				return fmt.Errorf("the %s index needs every block and can't be used with pruning", index.name())
+			}
+		}
+
+		return putMetaInt(tx, pruneDepthKey, depth)
+	})
+}
+
+// pruneBlocks drops the transactions and undo data of the blocks more than
+// pruneDepth below the tip, keeping their headers. The UTXO cache is
+// flushed first, as the blocks since its marker on disk are needed to
+// catch it up after a crash
+func (bc *blockchain) pruneBlocks() {
+	depth := bc.pruneDepth()
+	if depth == 0 {
+		return
+	}
+
+	if cache, ok := bc.db.(cacheFlusher); ok {
+		err := cache.flushCache()
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	pruned := 0
+	for {
+		done := true
+
+		err := bc.db.Update(func(tx StoreTx) error {
+			if !bc.indexSynced(tx, heightIndex{}) {
+				return nil
+			}
+
+			from := metaInt(tx, pruneHeightKey)
+			to := blockInTx(tx, tipInTx(tx)).Height - depth + 1 // first height kept
+			if to > from+pruneBatch {
+				to, done = from+pruneBatch, false
+			}
+			if to <= from {
+				return nil
+			}
+
+			heights := tx.Bucket([]byte(heightIndexBucket))
+			undo := tx.Bucket([]byte(undoBucket))
+			for height := from; height < to; height++ {
+				hash := heights.Get(heightKey(height))
+				b := blockInTx(tx, hash)
+				if b == nil {
+					return fmt.Errorf("block %x at height %d is missing", hash, height)
+				}
+
+				err := putBlock(tx, b.pruned())
+				if err != nil {
+					return err
+				}
+
+				if undo != nil {
+					err = undo.Delete(hash)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			pruned += to - from
+
+			return putMetaInt(tx, pruneHeightKey, to)
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+
+		if done {
+			break
+		}
+	}
+
+	if pruned > 0 {
+		fmt.Printf("Pruned the transactions of %d blocks below height %d\n", pruned, bc.pruneHeight())
+	}
+}
+
+// compactStore gives the space freed by pruning back to the filesystem
+func (bc *blockchain) compactStore() {
+	store, ok := bc.db.(storeCompactor)
+	if !ok {
+		return
+	}
+
+	before, after, err := store.compact()
+	if err != nil {
+		log.Panic("ERROR: Can't compact the database: ", err)
+	}
+
+	if after < before {
+		fmt.Printf("Compacted the database from %d to %d bytes\n", before, after)
+	}
+}
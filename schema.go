@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+)
+
+const metaBucket = "meta"
+const schemaVersionKey = "version"
+
+// schemaVersion is the database layout this binary writes. Databases
+// written before the version was recorded are version 0
+const schemaVersion = 2
+
+// schemaMigration upgrades a database from Version-1 to Version
+type schemaMigration struct {
+	Version     int
+	Description string
+	Migrate     func(bc *blockchain) error
+}
+
+var schemaMigrations = []schemaMigration{
+	{1, "add the height index and store the UTXO set per output", migrateHeightIndexAndUTXOs},
+	{2, "require version 2 blocks above the tip", migrateVersionHeight},
+}
+
+// storeBackup is implemented by stores that can be copied before a migration
+type storeBackup interface {
+	backup(version int) (string, error)
+}
+
+func readSchemaVersion(tx StoreTx) int {
+	return metaInt(tx, schemaVersionKey)
+}
+
+func writeSchemaVersion(tx StoreTx, version int) error {
+	return putMetaInt(tx, schemaVersionKey, version)
+}
+
+// metaInt returns the number stored under key in the meta bucket, 0 if there is none
+func metaInt(tx StoreTx, key string) int {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta == nil {
+		return 0
+	}
+
+	value := meta.Get([]byte(key))
+	if len(value) != 4 {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint32(value))
+}
+
+func putMetaInt(tx StoreTx, key string, value int) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(value))
+
+	return meta.Put([]byte(key), data[:])
+}
+
+// upgradeSchema migrates the database one version at a time up to
+// schemaVersion, backing it up first. It refuses a database written by a
+// newer binary, whose layout it can't know
+func (bc *blockchain) upgradeSchema() {
+	var version int
+
+	err := bc.db.View(func(tx StoreTx) error {
+		version = readSchemaVersion(tx)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if version > schemaVersion {
+		fmt.Printf("The database has schema version %d, this binary supports up to version %d. Upgrade the binary to open it.\n", version, schemaVersion)
+		bc.db.Close()
+		os.Exit(1)
+	}
+	if version == schemaVersion {
+		return
+	}
+
+	if store, ok := bc.db.(storeBackup); ok {
+		path, err := store.backup(version)
+		if err != nil {
+			log.Panic("ERROR: Can't back up the database before migrating it: ", err)
+		}
+		if path != "" {
+			fmt.Printf("Backed up the database with schema version %d to %s\n", version, path)
+		}
+	}
+
+	for _, migration := range schemaMigrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		fmt.Printf("Migrating the database to schema version %d: %s\n", migration.Version, migration.Description)
+		err := migration.Migrate(bc)
+		if err != nil {
+			log.Panic("ERROR: Migration to schema version ", migration.Version, " failed: ", err)
+		}
+
+		err = bc.db.Update(func(tx StoreTx) error {
+			return writeSchemaVersion(tx, migration.Version)
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// migrateHeightIndexAndUTXOs upgrades the databases written before schema
+// versioning: the height index is created, to be built by syncIndexes, and
+// a UTXO set in the per-transaction layout is rebuilt
+func migrateHeightIndexAndUTXOs(bc *blockchain) error {
+	err := bc.db.Update(func(tx StoreTx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(heightIndexBucket))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.migrateChainstate()
+
+	return nil
+}
+
+// migrateVersionHeight lets the blocks of older versions already stored
+// stand, and requires blockVersion from the next block on. Databases created
+// at schema version 2 require it from genesis
+func migrateVersionHeight(bc *blockchain) error {
+	return bc.db.Update(func(tx StoreTx) error {
+		tip := blockInTx(tx, tipInTx(tx))
+		if tip == nil {
+			return fmt.Errorf("the tip %x is missing", tipInTx(tx))
+		}
+
+		return putMetaInt(tx, versionHeightKey, tip.Height+1)
+	})
+}
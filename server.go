@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"time"
 )
 
 const protocol = "tcp"
@@ -17,7 +18,7 @@ const commandLength = 12
 
 var nodeAddress string
 var miningAddress string
-var knownNodes = []string{"localhost:3000"}
+var knownNodes = []string{activeChain.SeedNode}
 var blocksInTransit = [][]byte{}
 var mempool = make(map[string]Transaction)
 
@@ -52,9 +53,10 @@ type tx struct {
 }
 
 type verzion struct {
-	Version    int
-	BestHeight int
-	AddrFrom   string
+	Version     int
+	BestHeight  int
+	AddrFrom    string
+	PruneHeight int // lowest height the node serves blocks from, 0 if it has them all
 }
 
 func commandToBytes(command string) []byte {
@@ -162,7 +164,7 @@ func sendTx(addr string, tnx *Transaction) {
 
 func sendVersion(addr string, bc *blockchain) {
 	bestHeight := bc.getBestHeight()
-	payload := gobEncode(verzion{nodeVersion, bestHeight, nodeAddress})
+	payload := gobEncode(verzion{nodeVersion, bestHeight, nodeAddress, bc.pruneHeight()})
 
 	request := append(commandToBytes("version"), payload...)
 
@@ -200,9 +202,12 @@ func handleBlock(request []byte, bc *blockchain) {
 	block := deserialize(blockData)
 
 	fmt.Println("Recevied a new block!")
-	bc.addBlock(block)
-
-	fmt.Printf("Added block %x\n", block.Hash)
+	err = bc.addBlock(block)
+	if err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+	} else {
+		fmt.Printf("Added block %x\n", block.Hash)
+	}
 
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
@@ -210,8 +215,9 @@ func handleBlock(request []byte, bc *blockchain) {
 
 		blocksInTransit = blocksInTransit[1:]
 	} else {
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.reindex()
+		bc.syncIndexes()
+		bc.checkSnapshot()
+		bc.pruneBlocks()
 	}
 }
 
@@ -229,6 +235,11 @@ func handleInv(request []byte, bc *blockchain) {
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
+		// the newest block is listed first, the blocks are requested from the
+		// oldest so that each one arrives after the block it builds on
+		for i, j := 0, len(payload.Items)-1; i < j; i, j = i+1, j-1 {
+			payload.Items[i], payload.Items[j] = payload.Items[j], payload.Items[i]
+		}
 		blocksInTransit = payload.Items
 
 		blockHash := payload.Items[0]
@@ -264,6 +275,9 @@ func handleGetBlocks(request []byte, bc *blockchain) {
 	}
 
 	blocks := bc.getBlockHashes()
+	if pruneHeight := bc.pruneHeight(); pruneHeight > 0 {
+		blocks = blocks[:len(blocks)-pruneHeight] // only the blocks it can still send
+	}
 	sendInv(payload.AddrFrom, "block", blocks)
 }
 
@@ -280,7 +294,7 @@ func handleGetData(request []byte, bc *blockchain) {
 
 	if payload.Type == "block" {
 		block, err := bc.getBlock([]byte(payload.ID))
-		if err != nil {
+		if err != nil || block.Pruned {
 			return
 		}
 
@@ -309,7 +323,12 @@ func handleTx(request []byte, bc *blockchain) {
 
 	txData := payload.Transaction
 	tx := deserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
+
+	err = acceptToMempool(&tx, bc)
+	if err != nil {
+		fmt.Printf("Rejected transaction %x: %s\n", tx.ID, err)
+		return
+	}
 
 	if nodeAddress == knownNodes[0] {
 		for _, node := range knownNodes {
@@ -321,11 +340,19 @@ func handleTx(request []byte, bc *blockchain) {
 		if len(mempool) >= 2 && len(miningAddress) > 0 {
 		MineTransactions:
 			var txs []*Transaction
+			fees := 0
 
+			height := bc.getBestHeight() + 1
 			for id := range mempool {
 				tx := mempool[id]
-				if bc.verifyTransaction(&tx) {
+				if bc.validateTransaction(&tx, height, time.Now().Unix()) != nil {
+					continue
+				}
+
+				fee, err := bc.transactionFee(&tx)
+				if err == nil {
 					txs = append(txs, &tx)
+					fees += fee
 				}
 			}
 
@@ -334,12 +361,14 @@ func handleTx(request []byte, bc *blockchain) {
 				return
 			}
 
-			cbTx := newCoinbaseTX(miningAddress, "")
-			txs = append(txs, cbTx)
+			cbTx := newCoinbaseTX(miningAddress, "", fees)
+			txs = append([]*Transaction{cbTx}, txs...)
 
-			newBlock := bc.mineBlock(txs)
-			UTXOSet := UTXOSet{bc}
-			UTXOSet.reindex()
+			newBlock, err := bc.mineBlock(txs)
+			if err != nil {
+				fmt.Printf("Can't mine a block: %s\n", err)
+				return
+			}
 
 			fmt.Println("New block is mined!")
 
@@ -353,6 +382,7 @@ func handleTx(request []byte, bc *blockchain) {
 					sendInv(node, "block", [][]byte{newBlock.Hash})
 				}
 			}
+			bc.pruneBlocks()
 
 			if len(mempool) > 0 {
 				goto MineTransactions
@@ -375,7 +405,9 @@ func handleVersion(request []byte, bc *blockchain) {
 	myBestHeight := bc.getBestHeight()
 	foreignerBestHeight := payload.BestHeight
 
-	if myBestHeight < foreignerBestHeight {
+	if myBestHeight < foreignerBestHeight && myBestHeight+1 < payload.PruneHeight {
+		fmt.Printf("Peer %s has pruned the blocks below height %d and can't serve the ones this node needs\n", payload.AddrFrom, payload.PruneHeight)
+	} else if myBestHeight < foreignerBestHeight {
 		sendGetBlocks(payload.AddrFrom)
 	} else if myBestHeight > foreignerBestHeight {
 		sendVersion(payload.AddrFrom, bc)
@@ -418,7 +450,7 @@ func handleConnection(conn net.Conn, bc *blockchain) {
 }
 
 
-func startServer(nodeID, minerAddress string) {
+func startServer(nodeID, minerAddress string, indexes, dropIndexes []chainIndex, pruneDepth int) {
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
 	miningAddress = minerAddress
 	ln, err := net.Listen(protocol, nodeAddress)
@@ -428,9 +460,41 @@ func startServer(nodeID, minerAddress string) {
 	defer ln.Close()
 
 	bc := newBlockchain(nodeID)
+	if len(indexes) > 0 && (pruneDepth > 0 || bc.pruneDepth() > 0 || bc.pruneHeight() > 0) {
+		log.Panic("ERROR: The transaction and address indexes need every block and can't be used with pruning or before the blocks below a UTXO snapshot are downloaded")
+	}
+	if pruneDepth > 0 {
+		err := bc.setPruneDepth(pruneDepth)
+		if err != nil {
+			log.Panic("ERROR: Can't prune: ", err)
+		}
+	}
+	bc.checkSnapshot()
+	if depth := bc.pruneDepth(); depth > 0 {
+		fmt.Printf("Pruning is on, keeping the transactions of the last %d blocks\n", depth)
+		bc.pruneBlocks()
+		bc.compactStore()
+	}
+	dropped := false
+	for _, index := range dropIndexes {
+		if bc.disableIndex(index) {
+			fmt.Printf("Dropped the %s index\n", index.name())
+			dropped = true
+		}
+	}
+	if dropped {
+		bc.compactStore()
+	}
+	for _, index := range indexes {
+		bc.enableIndex(index)
+	}
+	go bc.syncIndexes()
 
 	if nodeAddress != knownNodes[0] {
 		sendVersion(knownNodes[0], bc)
+		if bc.snapshotPending() {
+			sendGetBlocks(knownNodes[0]) // for the blocks below the loaded UTXO snapshot
+		}
 	}
 
 	for {
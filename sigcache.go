@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sync"
+)
+
+const maxSigCacheEntries = 50000
+
+// sigCache remembers signature checks that passed, so a transaction verified
+// when it entered the mempool isn't verified again when it is mined
+type sigCache struct {
+	mu      sync.RWMutex
+	entries map[[32]byte]struct{}
+	max     int
+}
+
+var signatureCache = newSigCache(maxSigCacheEntries)
+
+func newSigCache(max int) *sigCache {
+	return &sigCache{entries: make(map[[32]byte]struct{}), max: max}
+}
+
+func sigCacheKey(check sigCheck) [32]byte {
+	var buf bytes.Buffer
+	writeBytes(&buf, check.PubKey)
+	writeBytes(&buf, check.Data)
+	writeBytes(&buf, check.Signature)
+
+	return sha256.Sum256(buf.Bytes())
+}
+
+func (c *sigCache) contains(check sigCheck) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.entries[sigCacheKey(check)]
+	return ok
+}
+
+// add stores a valid check, evicting an arbitrary entry when the cache is full
+func (c *sigCache) add(check sigCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.max {
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+	c.entries[sigCacheKey(check)] = struct{}{}
+}
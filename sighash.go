@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// sigHashType selects the parts of a transaction a signature commits to.
+// It is appended to the signature as a trailing byte
+type sigHashType byte
+
+const (
+	sigHashAll          sigHashType = 0x01 // all inputs and outputs
+	sigHashNone         sigHashType = 0x02 // all inputs, no outputs
+	sigHashSingle       sigHashType = 0x03 // all inputs and the output with the same index
+	sigHashAnyoneCanPay sigHashType = 0x80 // flag: only the signed input, others may be added
+)
+
+var sigHashNames = map[sigHashType]string{
+	sigHashAll:    "ALL",
+	sigHashNone:   "NONE",
+	sigHashSingle: "SINGLE",
+}
+
+func (t sigHashType) base() sigHashType {
+	return t &^ sigHashAnyoneCanPay
+}
+
+func (t sigHashType) anyoneCanPay() bool {
+	return t&sigHashAnyoneCanPay != 0
+}
+
+func (t sigHashType) valid() bool {
+	_, ok := sigHashNames[t.base()]
+	return ok
+}
+
+func (t sigHashType) String() string {
+	name, ok := sigHashNames[t.base()]
+	if !ok {
+		return fmt.Sprintf("UNKNOWN(%#x)", byte(t))
+	}
+	if t.anyoneCanPay() {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+// parseSigHashType parses ALL, NONE or SINGLE optionally followed by |ANYONECANPAY
+func parseSigHashType(value string) (sigHashType, error) {
+	parts := strings.Split(strings.ToUpper(value), "|")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "ANYONECANPAY") {
+		return 0, fmt.Errorf("invalid sighash type %q", value)
+	}
+
+	for t, name := range sigHashNames {
+		if name == parts[0] {
+			if len(parts) == 2 {
+				t |= sigHashAnyoneCanPay
+			}
+			return t, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid sighash type %q", value)
+}
+
+// sigHash returns the digest input inID signs under hashType. It is the
+// double SHA-256 of a fixed binary serialization of the committed parts:
+// the hash type, the version from version 2, the lock time, the inputs, the
+// outputs and the output being spent
+func (tx *Transaction) sigHash(inID int, prevOut TXOutput, hashType sigHashType) ([]byte, error) {
+	if !hashType.valid() {
+		return nil, fmt.Errorf("invalid sighash type %#x", byte(hashType))
+	}
+
+	if hashType.base() == sigHashSingle && inID >= len(tx.Vout) {
+		return nil, fmt.Errorf("SINGLE signature of input %d has no matching output", inID)
+	}
+
+	var buf bytes.Buffer
+	writeUint64(&buf, uint64(hashType))
+	if tx.Version >= 2 {
+		writeUint64(&buf, uint64(tx.Version))
+	}
+	writeUint64(&buf, uint64(tx.LockTime))
+
+	if hashType.anyoneCanPay() {
+		writeUint64(&buf, 1)
+		writeSigHashInput(&buf, tx.Vin[inID], true)
+	} else {
+		writeUint64(&buf, uint64(len(tx.Vin)))
+		for i, vin := range tx.Vin {
+			// NONE and SINGLE let the other inputs change their sequence
+			writeSigHashInput(&buf, vin, i == inID || hashType.base() == sigHashAll)
+		}
+		writeUint64(&buf, uint64(inID))
+	}
+
+	switch hashType.base() {
+	case sigHashAll:
+		writeUint64(&buf, uint64(len(tx.Vout)))
+		for _, out := range tx.Vout {
+			writeSigHashOutput(&buf, out)
+		}
+	case sigHashNone:
+		writeUint64(&buf, 0)
+	case sigHashSingle:
+		writeUint64(&buf, 1)
+		writeSigHashOutput(&buf, tx.Vout[inID])
+	}
+
+	writeSigHashOutput(&buf, prevOut)
+
+	return doubleSHA256(buf.Bytes()), nil
+}
+
+func writeSigHashInput(buf *bytes.Buffer, vin TXInput, withSequence bool) {
+	writeBytes(buf, vin.Txid)
+	writeUint64(buf, uint64(vin.Vout))
+	if withSequence {
+		writeUint64(buf, uint64(vin.Sequence))
+	} else {
+		writeUint64(buf, 0)
+	}
+}
+
+func writeSigHashOutput(buf *bytes.Buffer, out TXOutput) {
+	writeUint64(buf, uint64(out.Value))
+	writeUint64(buf, uint64(out.ScriptType))
+	writeBytes(buf, out.PubKeyHash)
+	writeBytes(buf, out.Data)
+}
+
+func writeUint64(buf *bytes.Buffer, value uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], value)
+	buf.Write(b[:])
+}
+
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	writeUint64(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// signInput signs input inID spending prevOut and returns the signature followed by the hash type
+func (tx *Transaction) signInput(wallet *Wallet, inID int, prevOut TXOutput, hashType sigHashType) []byte {
+	digest, err := tx.sigHash(inID, prevOut, hashType)
+	if err != nil {
+		log.Panic("ERROR: ", err)
+	}
+
+	return append(wallet.sign(digest), byte(hashType))
+}
+
+// addInputSignature queues the check of signature of input inID spending
+// prevOut by pubKey on queue and returns false if no digest can be computed.
+// Signatures without a hash type byte predate sighash flags and are checked
+// against the legacy signature data. That data is the printed transaction,
+// of which ECDSA only signs a prefix, so only version 0 transactions may use it
+func (tx *Transaction) addInputSignature(queue *sigQueue, inID int, prevOut TXOutput, pubKey, signature []byte) bool {
+	if len(signature) != signatureLen+1 {
+		if tx.Version > 0 {
+			return false
+		}
+
+		queue.add(pubKey, tx.legacySignatureData(inID, prevOut), signature)
+		return true
+	}
+
+	digest, err := tx.sigHash(inID, prevOut, sigHashType(signature[signatureLen]))
+	if err != nil {
+		return false
+	}
+
+	queue.add(pubKey, digest, signature[:signatureLen])
+	return true
+}
+
+// isStandardSignature reports whether signature of pubKey is a canonical signature with a valid hash type
+func isStandardSignature(pubKey, signature []byte) bool {
+	if len(signature) != signatureLen+1 || !sigHashType(signature[signatureLen]).valid() {
+		return false
+	}
+
+	// Ed25519 signatures have a single valid encoding
+	return pubKeyType(pubKey) == keyEd25519 || isCanonicalSignature(signature[:signatureLen])
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"log"
+	"math/big"
+)
+
+const scalarLen = 32               // bytes of a P-256 coordinate or scalar
+const signatureLen = 2 * scalarLen // canonical r || s
+const compressedPubKeyLen = 1 + scalarLen
+
+// signData signs data with privKey and returns the canonical signature:
+// r || s, each left padded to 32 bytes, with s normalized to the lower half
+// of the curve order so a third party can't flip it to n - s
+func signData(privKey ecdsa.PrivateKey, data []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	n := privKey.Curve.Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+
+	signature := make([]byte, signatureLen)
+	r.FillBytes(signature[:scalarLen])
+	s.FillBytes(signature[scalarLen:])
+
+	return signature
+}
+
+// isCanonicalSignature reports whether signature has the fixed width low-S encoding produced by signData
+func isCanonicalSignature(signature []byte) bool {
+	if len(signature) != signatureLen {
+		return false
+	}
+
+	s := new(big.Int).SetBytes(signature[scalarLen:])
+	halfOrder := new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+	return s.Sign() > 0 && s.Cmp(halfOrder) <= 0
+}
+
+// encodePubKey returns the compressed SEC1 encoding of pubKey
+func encodePubKey(pubKey ecdsa.PublicKey) []byte {
+	return elliptic.MarshalCompressed(pubKey.Curve, pubKey.X, pubKey.Y)
+}
+
+// decodePubKeys returns the candidate points encoded by pubKey. Compressed
+// and uncompressed SEC1 keys decode to one point; legacy keys are X || Y
+// with leading zero bytes dropped, so every split that lands on the curve
+// is a candidate
+func decodePubKeys(pubKey []byte) []ecdsa.PublicKey {
+	curve := elliptic.P256()
+
+	if len(pubKey) == compressedPubKeyLen && (pubKey[0] == 2 || pubKey[0] == 3) {
+		x, y := elliptic.UnmarshalCompressed(curve, pubKey)
+		if x == nil {
+			return nil
+		}
+		return []ecdsa.PublicKey{{Curve: curve, X: x, Y: y}}
+	}
+
+	if len(pubKey) == 1+signatureLen && pubKey[0] == 4 {
+		x, y := elliptic.Unmarshal(curve, pubKey)
+		if x == nil {
+			return nil
+		}
+		return []ecdsa.PublicKey{{Curve: curve, X: x, Y: y}}
+	}
+
+	var keys []ecdsa.PublicKey
+	for _, split := range legacySplits(len(pubKey)) {
+		x := new(big.Int).SetBytes(pubKey[:split])
+		y := new(big.Int).SetBytes(pubKey[split:])
+		if curve.IsOnCurve(x, y) {
+			keys = append(keys, ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+		}
+	}
+
+	return keys
+}
+
+// legacySplits lists the positions where a legacy concatenation of two
+// unpadded 32 byte values of total length n may be split, the middle first
+func legacySplits(n int) []int {
+	if n == 0 || n > signatureLen {
+		return nil
+	}
+
+	splits := []int{n / 2}
+	for split := n - scalarLen; split <= scalarLen; split++ {
+		if split > 0 && split != n/2 {
+			splits = append(splits, split)
+		}
+	}
+
+	return splits
+}
+
+// verifySignature checks signature of data against pubKey with the scheme
+// of its key type. Besides the canonical encodings it accepts the unpadded
+// r || s signatures and X || Y keys of older wallets so existing chain data
+// keeps verifying
+func verifySignature(pubKey []byte, data []byte, signature []byte) bool {
+	if pubKeyType(pubKey) == keyEd25519 {
+		return verifyEd25519(pubKey, data, signature)
+	}
+
+	keys := decodePubKeys(pubKey)
+	if len(keys) == 0 {
+		return false
+	}
+
+	splits := []int{scalarLen}
+	if len(signature) != signatureLen {
+		splits = legacySplits(len(signature))
+	}
+
+	for _, split := range splits {
+		r := new(big.Int).SetBytes(signature[:split])
+		s := new(big.Int).SetBytes(signature[split:])
+
+		for i := range keys {
+			if ecdsa.Verify(&keys[i], data, r, s) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// sigCheck is a signature verification queued on a sigQueue
+type sigCheck struct {
+	PubKey    []byte
+	Data      []byte
+	Signature []byte
+	Tx        int // index of the transaction the signature belongs to
+}
+
+// sigQueue collects the signature checks of one or more transactions, so
+// the scripts of a whole block are evaluated before any signature is
+// verified. Each check is then verified on its own with the scheme of its
+// public key, the checks spread over a pool of at most GOMAXPROCS workers.
+//
+// This is not batch verification. Ed25519 signatures could be verified
+// together with one multi-scalar multiplication, but the standard library
+// only verifies them one at a time and this module has no Edwards curve
+// arithmetic to build the batch equation on. ECDSA has no batch equation
+type sigQueue struct {
+	checks []sigCheck
+	tx     int
+}
+
+// add queues a check for the transaction set with setTx
+func (q *sigQueue) add(pubKey, data, signature []byte) {
+	q.checks = append(q.checks, sigCheck{pubKey, data, signature, q.tx})
+}
+
+func (q *sigQueue) setTx(index int) {
+	q.tx = index
+}
+
+// verify returns the lowest transaction index with an invalid signature or
+// -1 if all are valid. Checks found in the signature cache are skipped and
+// the ones that pass are added to it
+func (q *sigQueue) verify() int {
+	var pending []sigCheck
+	for _, check := range q.checks {
+		if !signatureCache.contains(check) {
+			pending = append(pending, check)
+		}
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+
+	jobs := make(chan sigCheck)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	bad := -1
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for check := range jobs {
+				if verifySignature(check.PubKey, check.Data, check.Signature) {
+					signatureCache.add(check)
+					continue
+				}
+
+				mu.Lock()
+				if bad < 0 || check.Tx < bad {
+					bad = check.Tx
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, check := range pending {
+		jobs <- check
+	}
+	close(jobs)
+	wg.Wait()
+
+	return bad
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const snapshotMagic = "utxo"
+const snapshotVersion = 1
+const snapshotKey = "snapshot"             // meta key of the base of a loaded UTXO snapshot not checked yet
+const snapshotCheckBucket = "snapshotcheck" // UTXO set rebuilt from genesis to check a loaded snapshot
+const maxChunkSize = 64 << 20               // longer chunks mean a corrupt file
+
+var errSnapshotWritten = errors.New("snapshot written") // rolls back the blocks disconnected for a dump
+
+// A UTXO snapshot file holds, after a magic and a version, the chain name,
+// the hash and height of the block the UTXO set is at, the headers of the
+// blocks up to it, the UTXO entries in outpoint order and their hash.
+// Variable length fields are chunks: a big-endian uint32 length and the bytes
+
+// snapshotBase identifies the UTXO set held by a snapshot
+type snapshotBase struct {
+	Height    int
+	BlockHash []byte
+	Hash      []byte // of the block hash, the height and the entries, see hashUTXOEntry
+}
+
+func writeChunk(w io.Writer, data []byte) error {
+	err := binary.Write(w, binary.BigEndian, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func readChunk(r io.Reader) ([]byte, error) {
+	var size uint32
+	err := binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxChunkSize {
+		return nil, fmt.Errorf("chunk of %d bytes is too long", size)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+// newUTXOHasher starts the hash of a UTXO set at a block; the entries are
+// added in outpoint order with hashUTXOEntry
+func newUTXOHasher(blockHash []byte, height int) hash.Hash {
+	h := sha256.New()
+	h.Write(blockHash)
+	binary.Write(h, binary.BigEndian, uint32(height))
+
+	return h
+}
+
+// hashUTXOEntry adds an entry in a fixed encoding: the gob encoding of the
+// stored value depends on the type IDs of the process that wrote it
+func hashUTXOEntry(h hash.Hash, key, value []byte) error {
+	entry, err := decodeUTXOEntry(value)
+	if err != nil {
+		return err
+	}
+
+	h.Write(key)
+	h.Write(entry.canonical())
+
+	return nil
+}
+
+// canonical encodes the entry the same way in every process
+func (e utxoEntry) canonical() []byte {
+	var buf bytes.Buffer
+	writeSigHashOutput(&buf, e.Output)
+	writeUint64(&buf, uint64(e.Height))
+	if e.Coinbase {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+
+	return buf.Bytes()
+}
+
+// hashUTXOBucket hashes the UTXO set kept in bucket as at the given block
+func hashUTXOBucket(bucket StoreBucket, blockHash []byte, height int) ([]byte, error) {
+	h := newUTXOHasher(blockHash, height)
+
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		err := hashUTXOEntry(h, k, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h.Sum(nil), nil
+}
+
+// assumedUTXOHashes returns the hashes of the UTXO snapshots loadtxoutset
+// trusts, by height, from ASSUMEUTXO=HEIGHT:HASH[,HEIGHT:HASH...]. Every
+// deployment mines its own genesis block, so the chain params can't carry them
+func assumedUTXOHashes() (map[int][]byte, error) {
+	hashes := make(map[int][]byte)
+
+	for _, item := range strings.Split(os.Getenv("ASSUMEUTXO"), ",") {
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q is not HEIGHT:HASH", item)
+		}
+		height, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("%q is not HEIGHT:HASH", item)
+		}
+		hash, err := hex.DecodeString(parts[1])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%q is not HEIGHT:HASH", item)
+		}
+
+		hashes[height] = hash
+	}
+
+	return hashes, nil
+}
+
+// dumpUTXOSet writes a snapshot of the UTXO set at height of the active
+// chain. Below the tip the set is rolled back with the undo data, in a
+// transaction that is then discarded
+func (bc *blockchain) dumpUTXOSet(w io.Writer, height int) (snapshotBase, int, error) {
+	var base snapshotBase
+	count := 0
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		if !bc.indexSynced(tx, chainstate{}) || !bc.indexSynced(tx, heightIndex{}) {
+			return errors.New("the UTXO set or the height index is not in step with the tip")
+		}
+
+		tip := blockInTx(tx, tipInTx(tx))
+		if height > tip.Height {
+			return fmt.Errorf("the chain is only at height %d", tip.Height)
+		}
+
+		for b := tip; b.Height > height; b = blockInTx(tx, b.PrevBlockHash) {
+			if !(chainstate{}).canDisconnect(tx, b) {
+				return fmt.Errorf("there is no undo data to take the UTXO set back past block %x at height %d", b.Hash, b.Height)
+			}
+
+			err := chainstate{}.disconnect(tx, b)
+			if err != nil {
+				return err
+			}
+		}
+
+		heights := tx.Bucket([]byte(heightIndexBucket))
+		base.Height = height
+		base.BlockHash = append([]byte{}, heights.Get(heightKey(height))...)
+
+		_, err := w.Write([]byte(snapshotMagic))
+		if err != nil {
+			return err
+		}
+		err = binary.Write(w, binary.BigEndian, uint32(snapshotVersion))
+		if err != nil {
+			return err
+		}
+		err = writeChunk(w, []byte(activeChain.Name))
+		if err != nil {
+			return err
+		}
+		err = writeChunk(w, base.BlockHash)
+		if err != nil {
+			return err
+		}
+		err = binary.Write(w, binary.BigEndian, uint32(height))
+		if err != nil {
+			return err
+		}
+
+		for h := 0; h <= height; h++ {
+			header := blockInTx(tx, heights.Get(heightKey(h)))
+			if !header.Pruned {
+				header = header.pruned()
+			}
+
+			err = writeChunk(w, header.serialize())
+			if err != nil {
+				return err
+			}
+		}
+
+		bucket := tx.Bucket([]byte(utxoBucket))
+		cursor := bucket.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			count++
+		}
+		err = binary.Write(w, binary.BigEndian, uint64(count))
+		if err != nil {
+			return err
+		}
+
+		hasher := newUTXOHasher(base.BlockHash, height)
+		cursor = bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			err = hashUTXOEntry(hasher, k, v)
+			if err != nil {
+				return err
+			}
+
+			err = writeChunk(w, k)
+			if err == nil {
+				err = writeChunk(w, v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		base.Hash = hasher.Sum(nil)
+
+		err = writeChunk(w, base.Hash)
+		if err != nil {
+			return err
+		}
+
+		return errSnapshotWritten
+	})
+	if err != errSnapshotWritten {
+		return snapshotBase{}, 0, err
+	}
+
+	return base, count, nil
+}
+
+// loadUTXOSnapshot fills an empty store with the headers and the UTXO set of
+// a snapshot, whose hash must be one configured in ASSUMEUTXO. The blocks
+// below the snapshot count as pruned until checkSnapshot has downloaded
+// and connected them
+func loadUTXOSnapshot(r io.Reader, store ChainStore) (snapshotBase, int, error) {
+	var base snapshotBase
+
+	magic := make([]byte, len(snapshotMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != snapshotMagic {
+		return base, 0, errors.New("not a UTXO snapshot")
+	}
+	var version uint32
+	err = binary.Read(r, binary.BigEndian, &version)
+	if err != nil {
+		return base, 0, err
+	}
+	if version != snapshotVersion {
+		return base, 0, fmt.Errorf("snapshot version %d is not supported", version)
+	}
+
+	chain, err := readChunk(r)
+	if err != nil {
+		return base, 0, err
+	}
+	if string(chain) != activeChain.Name {
+		return base, 0, fmt.Errorf("the snapshot is of the %s chain", chain)
+	}
+
+	base.BlockHash, err = readChunk(r)
+	if err != nil {
+		return base, 0, err
+	}
+	var height uint32
+	err = binary.Read(r, binary.BigEndian, &height)
+	if err != nil {
+		return base, 0, err
+	}
+	base.Height = int(height)
+
+	trusted, err := assumedUTXOHashes()
+	if err != nil {
+		return base, 0, fmt.Errorf("ASSUMEUTXO: %s", err)
+	}
+	if trusted[base.Height] == nil {
+		return base, 0, fmt.Errorf("no hash is configured for a UTXO snapshot at height %d, set ASSUMEUTXO=%d:HASH", base.Height, base.Height)
+	}
+
+	headers := make([]*block, base.Height+1)
+	for h := range headers {
+		data, err := readChunk(r)
+		if err != nil {
+			return base, 0, err
+		}
+		header, err := decodeBlock(data)
+		if err != nil {
+			return base, 0, fmt.Errorf("header %d: %s", h, err)
+		}
+
+		if h > 0 && !bytes.Equal(header.PrevBlockHash, headers[h-1].Hash) {
+			return base, 0, fmt.Errorf("header %d doesn't follow header %d", h, h-1)
+		}
+		if header.Height != h || !header.Pruned || !newPow(header).validateHash() {
+			return base, 0, fmt.Errorf("header %d is not valid", h)
+		}
+		headers[h] = header
+	}
+	if !bytes.Equal(headers[base.Height].Hash, base.BlockHash) {
+		return base, 0, errors.New("the headers don't lead to the snapshot block")
+	}
+
+	err = store.Update(func(tx StoreTx) error {
+		for _, name := range []string{blocksBucket, heightIndexBucket, utxoBucket, snapshotCheckBucket} {
+			_, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, header := range headers {
+			err := putBlock(tx, header)
+			if err != nil {
+				return err
+			}
+			err = heightIndex{}.connect(tx, header)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := setTip(tx, base.BlockHash)
+		if err != nil {
+			return err
+		}
+		err = setIndexTip(tx, heightIndex{}, base.BlockHash)
+		if err != nil {
+			return err
+		}
+		err = writeSchemaVersion(tx, schemaVersion)
+		if err != nil {
+			return err
+		}
+
+		return putMetaInt(tx, pruneHeightKey, base.Height+1)
+	})
+	if err != nil {
+		return base, 0, err
+	}
+
+	var count uint64
+	err = binary.Read(r, binary.BigEndian, &count)
+	if err != nil {
+		return base, 0, err
+	}
+
+	hasher := newUTXOHasher(base.BlockHash, base.Height)
+	for loaded := uint64(0); loaded < count; {
+		err = store.Update(func(tx StoreTx) error {
+			bucket := tx.Bucket([]byte(utxoBucket))
+
+			for n := 0; n < utxoFlushBatch && loaded < count; n++ {
+				key, err := readChunk(r)
+				if err != nil {
+					return err
+				}
+				if len(key) != sha256.Size+4 {
+					return errors.New("the snapshot holds an invalid outpoint")
+				}
+				value, err := readChunk(r)
+				if err != nil {
+					return err
+				}
+
+				err = hashUTXOEntry(hasher, key, value)
+				if err != nil {
+					return errors.New("the snapshot holds an invalid UTXO entry")
+				}
+				err = bucket.Put(key, value)
+				if err != nil {
+					return err
+				}
+				loaded++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return base, 0, err
+		}
+	}
+	base.Hash = hasher.Sum(nil)
+
+	written, err := readChunk(r)
+	if err != nil {
+		return base, 0, err
+	}
+	if !bytes.Equal(written, base.Hash) {
+		return base, 0, errors.New("the snapshot is corrupt, its entries don't match its hash")
+	}
+	if !bytes.Equal(trusted[base.Height], base.Hash) {
+		return base, 0, fmt.Errorf("the snapshot hash %x is not the configured %x", base.Hash, trusted[base.Height])
+	}
+
+	err = store.Update(func(tx StoreTx) error {
+		err := setIndexTip(tx, chainstate{}, base.BlockHash)
+		if err != nil {
+			return err
+		}
+
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+
+		return meta.Put([]byte(snapshotKey), gobEncode(base))
+	})
+
+	return base, int(count), err
+}
+
+// snapshotBaseInTx returns the base of a loaded snapshot that checkSnapshot hasn't finished with
+func snapshotBaseInTx(tx StoreTx) (snapshotBase, bool) {
+	var base snapshotBase
+
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta == nil {
+		return base, false
+	}
+	data := meta.Get([]byte(snapshotKey))
+	if data == nil {
+		return base, false
+	}
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&base)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return base, true
+}
+
+// snapshotPending reports whether the blocks below a loaded snapshot are still to be checked
+func (bc *blockchain) snapshotPending() bool {
+	pending := false
+
+	err := bc.db.View(func(tx StoreTx) error {
+		_, pending = snapshotBaseInTx(tx)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return pending
+}
+
+// snapshotCheck connects the blocks below a loaded snapshot to a UTXO set
+// of its own, validating them by the rules the chainstate applies. The
+// blocks are assumed valid by the snapshot, so they are never disconnected
+type snapshotCheck struct{}
+
+func (snapshotCheck) name() string {
+	return snapshotCheckBucket
+}
+
+func (snapshotCheck) connect(tx StoreTx, b *block) error {
+	if b.Pruned {
+		return fmt.Errorf("block %x is not downloaded", b.Hash)
+	}
+
+	bucket := tx.Bucket([]byte(snapshotCheckBucket))
+
+	err := checkBlockVersion(b, blockInTx(tx, b.PrevBlockHash), metaInt(tx, versionHeightKey))
+	if err == nil {
+		err = checkBlockTransactions(utxoView{tx, bucket, b.PrevBlockHash}, b.Transactions, b.Height, b.Timestamp, b.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("block %x at height %d: %w", b.Hash, b.Height, err)
+	}
+
+	_, err = connectUTXOs(bucket, b)
+
+	return err
+}
+
+func (snapshotCheck) disconnect(tx StoreTx, b *block) error {
+	return errors.New("the blocks below a UTXO snapshot are never disconnected")
+}
+
+// checkSnapshot connects the downloaded blocks below a loaded snapshot, in
+// batches, and once it reaches the snapshot height compares the UTXO set
+// they give with the snapshot. A match makes the chain a full one; a
+// mismatch means the configured hash vouched for a bad snapshot
+func (bc *blockchain) checkSnapshot() {
+	for {
+		var base snapshotBase
+		var pending bool
+		checked := -1 // height checked up to in this batch
+		finished := false
+
+		err := bc.db.Update(func(tx StoreTx) error {
+			base, pending = snapshotBaseInTx(tx)
+			if !pending {
+				return nil
+			}
+
+			height := 0
+			if tip, _ := indexTip(tx, snapshotCheck{}); tip != nil {
+				height = blockInTx(tx, tip).Height + 1
+			}
+
+			heights := tx.Bucket([]byte(heightIndexBucket))
+			for end := height + indexBuildBatch; height <= base.Height && height < end; height++ {
+				b := blockInTx(tx, heights.Get(heightKey(height)))
+				if b.Pruned {
+					break
+				}
+
+				err := snapshotCheck{}.connect(tx, b)
+				if err != nil {
+					return err
+				}
+				err = setIndexTip(tx, snapshotCheck{}, b.Hash)
+				if err != nil {
+					return err
+				}
+				checked = height
+			}
+
+			if height <= base.Height {
+				return nil
+			}
+
+			hash, err := hashUTXOBucket(tx.Bucket([]byte(snapshotCheckBucket)), base.BlockHash, base.Height)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(hash, base.Hash) {
+				return fmt.Errorf("the UTXO set of the blocks up to height %d has hash %x, not the snapshot's %x", base.Height, hash, base.Hash)
+			}
+
+			err = tx.DeleteBucket([]byte(snapshotCheckBucket))
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket([]byte(indexStateBucket)).Delete([]byte(snapshotCheckBucket))
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket([]byte(metaBucket)).Delete([]byte(snapshotKey))
+			if err != nil {
+				return err
+			}
+			finished = true
+
+			return putMetaInt(tx, pruneHeightKey, 0)
+		})
+		if err != nil {
+			log.Panic("ERROR: The UTXO snapshot is not valid: ", err)
+		}
+
+		if finished {
+			fmt.Printf("The UTXO snapshot at height %d matches the blocks downloaded from genesis\n", base.Height)
+			return
+		}
+		if checked < 0 {
+			return
+		}
+		fmt.Printf("Checked the blocks below the UTXO snapshot up to height %d of %d\n", checked, base.Height)
+	}
+}
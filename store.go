@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// ChainStore is the storage backend of a blockchain. It holds named
+// buckets of sorted key/value pairs: the blocks and the tip, the UTXO set,
+// the locked outpoints and one bucket per chain index. All reads and writes
+// go through a transaction; the writes of an Update are applied atomically
+// or not at all when it returns an error.
+//
+// Values returned by a transaction are only valid until it ends
+type ChainStore interface {
+	View(fn func(tx StoreTx) error) error
+	Update(fn func(tx StoreTx) error) error
+	Close() error
+}
+
+// StoreTx is a read-only or read-write transaction of a ChainStore
+type StoreTx interface {
+	Bucket(name []byte) StoreBucket // nil if the bucket doesn't exist
+	CreateBucket(name []byte) (StoreBucket, error)
+	CreateBucketIfNotExists(name []byte) (StoreBucket, error)
+	DeleteBucket(name []byte) error
+}
+
+type StoreBucket interface {
+	Get(key []byte) []byte // nil if the key doesn't exist
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() StoreCursor
+}
+
+// StoreCursor walks the keys of a bucket in byte order. It returns a nil
+// key past the last one
+type StoreCursor interface {
+	First() ([]byte, []byte)
+	Seek(seek []byte) ([]byte, []byte)
+	Next() ([]byte, []byte)
+}
+
+// keysCursor walks a sorted snapshot of keys, reading each value when it
+// is reached and skipping the keys deleted since the snapshot
+type keysCursor struct {
+	keys []string
+	get  func(key []byte) []byte
+	pos  int
+}
+
+func (c *keysCursor) First() ([]byte, []byte) {
+	c.pos = 0
+
+	return c.current()
+}
+
+func (c *keysCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.pos = sort.SearchStrings(c.keys, string(seek))
+
+	return c.current()
+}
+
+func (c *keysCursor) Next() ([]byte, []byte) {
+	c.pos++
+
+	return c.current()
+}
+
+func (c *keysCursor) current() ([]byte, []byte) {
+	for ; c.pos < len(c.keys); c.pos++ {
+		if value := c.get([]byte(c.keys[c.pos])); value != nil {
+			return []byte(c.keys[c.pos]), value
+		}
+	}
+
+	return nil, nil
+}
+
+var errBucketNotFound = errors.New("bucket not found")
+var errBucketExists = errors.New("bucket already exists")
+var errTxNotWritable = errors.New("transaction not writable")
+
+const tipKey = "l" // key of the hash of the last block in the blocks bucket
+
+// blockInTx reads a block inside a store transaction or returns nil if it isn't stored
+func blockInTx(tx StoreTx, hash []byte) *block {
+	data := tx.Bucket([]byte(blocksBucket)).Get(hash)
+	if data == nil {
+		return nil
+	}
+
+	return deserialize(data)
+}
+
+func putBlock(tx StoreTx, b *block) error {
+	return tx.Bucket([]byte(blocksBucket)).Put(b.Hash, b.serialize())
+}
+
+// tipInTx returns a copy of the hash of the last block of the active chain,
+// so it stays valid after the transaction
+func tipInTx(tx StoreTx) []byte {
+	return append([]byte{}, tx.Bucket([]byte(blocksBucket)).Get([]byte(tipKey))...)
+}
+
+func setTip(tx StoreTx, hash []byte) error {
+	return tx.Bucket([]byte(blocksBucket)).Put([]byte(tipKey), hash)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"os"
+)
+
+// boltStore keeps the chain in a Bolt database file
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) View(fn func(tx StoreTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// backup copies the database file next to it, named after its schema version
+func (s *boltStore) backup(version int) (string, error) {
+	path := fmt.Sprintf("%s.v%d.bak", s.db.Path(), version)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+
+	return path, err
+}
+
+// compact rewrites the database into a new file without the free pages
+// left by deleted data, then swaps it in. Bolt reuses free pages but never
+// shrinks its file, so this is the only way to reclaim the space. It is
+// skipped while less than a quarter of the file is free, and must not run
+// alongside other transactions. It returns the file size before and after
+func (s *boltStore) compact() (int64, int64, error) {
+	path := s.db.Path()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before := info.Size()
+	if int64(s.db.Stats().FreeAlloc) < before/4 {
+		return before, before, nil
+	}
+
+	compactPath := path + ".compact"
+	os.Remove(compactPath)
+	dst, err := bolt.Open(compactPath, 0600, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, src *bolt.Bucket) error {
+			return dst.Update(func(dstTx *bolt.Tx) error {
+				bucket, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+
+				return copyBoltBucket(bucket, src)
+			})
+		})
+	})
+	dst.Close()
+	if err != nil {
+		os.Remove(compactPath)
+		return 0, 0, err
+	}
+
+	err = s.db.Close()
+	if err != nil {
+		return 0, 0, err
+	}
+	err = os.Rename(compactPath, path)
+	if err != nil {
+		return 0, 0, err
+	}
+	s.db, err = bolt.Open(path, 0600, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	info, err = os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return before, info.Size(), nil
+}
+
+// copyBoltBucket copies the keys and nested buckets of src into dst
+func copyBoltBucket(dst, src *bolt.Bucket) error {
+	dst.FillPercent = 1 // keys arrive in order, so pages can be filled up
+
+	err := dst.SetSequence(src.Sequence())
+	if err != nil {
+		return err
+	}
+
+	cursor := src.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		if v != nil {
+			err = dst.Put(k, v)
+		} else {
+			var nested *bolt.Bucket
+			nested, err = dst.CreateBucket(k)
+			if err == nil {
+				err = copyBoltBucket(nested, src.Bucket(k))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Bucket(name []byte) StoreBucket {
+	bucket := t.tx.Bucket(name)
+	if bucket == nil {
+		return nil
+	}
+
+	return boltBucket{bucket}
+}
+
+func (t boltTx) CreateBucket(name []byte) (StoreBucket, error) {
+	bucket, err := t.tx.CreateBucket(name)
+	if err == bolt.ErrBucketExists {
+		return nil, errBucketExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return boltBucket{bucket}, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	bucket, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return boltBucket{bucket}, nil
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	err := t.tx.DeleteBucket(name)
+	if err == bolt.ErrBucketNotFound {
+		return errBucketNotFound
+	}
+
+	return err
+}
+
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+func (b boltBucket) Cursor() StoreCursor {
+	return b.Bucket.Cursor()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// memoryStore keeps the chain in memory, so tests and simulations can run
+// many nodes in one process. Committed buckets are never modified: a write
+// transaction copies the buckets it changes and swaps them in on success,
+// which gives readers a consistent snapshot as Bolt does
+type memoryStore struct {
+	mu      sync.RWMutex // guards buckets
+	writer  sync.Mutex   // one write transaction at a time
+	buckets map[string]map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStore) View(fn func(tx StoreTx) error) error {
+	s.mu.RLock()
+	buckets := s.buckets
+	s.mu.RUnlock()
+
+	return fn(&memoryTx{buckets: buckets})
+}
+
+func (s *memoryStore) Update(fn func(tx StoreTx) error) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+
+	s.mu.RLock()
+	buckets := make(map[string]map[string][]byte, len(s.buckets))
+	for name, bucket := range s.buckets {
+		buckets[name] = bucket
+	}
+	s.mu.RUnlock()
+
+	tx := &memoryTx{buckets: buckets, copied: make(map[string]bool), writable: true}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.buckets = tx.buckets
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+type memoryTx struct {
+	buckets  map[string]map[string][]byte
+	copied   map[string]bool // buckets already copied by this transaction
+	writable bool
+}
+
+func (t *memoryTx) Bucket(name []byte) StoreBucket {
+	if t.buckets[string(name)] == nil {
+		return nil
+	}
+
+	return memoryBucket{t, string(name)}
+}
+
+func (t *memoryTx) CreateBucket(name []byte) (StoreBucket, error) {
+	if !t.writable {
+		return nil, errTxNotWritable
+	}
+	if t.buckets[string(name)] != nil {
+		return nil, errBucketExists
+	}
+
+	t.buckets[string(name)] = make(map[string][]byte)
+	t.copied[string(name)] = true
+
+	return memoryBucket{t, string(name)}, nil
+}
+
+func (t *memoryTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	if bucket := t.Bucket(name); bucket != nil {
+		return bucket, nil
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *memoryTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return errTxNotWritable
+	}
+	if t.buckets[string(name)] == nil {
+		return errBucketNotFound
+	}
+
+	delete(t.buckets, string(name))
+	delete(t.copied, string(name))
+
+	return nil
+}
+
+// writableData returns the data of bucket name, copying it on the first
+// write. A bucket deleted since it was opened stays deleted
+func (t *memoryTx) writableData(name string) (map[string][]byte, error) {
+	if !t.writable {
+		return nil, errTxNotWritable
+	}
+	if t.buckets[name] == nil {
+		return nil, errBucketNotFound
+	}
+
+	if !t.copied[name] {
+		data := make(map[string][]byte, len(t.buckets[name]))
+		for k, v := range t.buckets[name] {
+			data[k] = v
+		}
+		t.buckets[name] = data
+		t.copied[name] = true
+	}
+
+	return t.buckets[name], nil
+}
+
+type memoryBucket struct {
+	tx   *memoryTx
+	name string
+}
+
+func (b memoryBucket) Get(key []byte) []byte {
+	return b.tx.buckets[b.name][string(key)]
+}
+
+func (b memoryBucket) Put(key, value []byte) error {
+	data, err := b.tx.writableData(b.name)
+	if err != nil {
+		return err
+	}
+
+	data[string(key)] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (b memoryBucket) Delete(key []byte) error {
+	data, err := b.tx.writableData(b.name)
+	if err != nil {
+		return err
+	}
+
+	delete(data, string(key))
+
+	return nil
+}
+
+func (b memoryBucket) ForEach(fn func(k, v []byte) error) error {
+	cursor := b.Cursor()
+
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		err := fn(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b memoryBucket) Cursor() StoreCursor {
+	data := b.tx.buckets[b.name]
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return &keysCursor{keys: keys, get: b.Get}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestMemoryStoreChain(t *testing.T) {
+	miner := newWallet(keyECDSA)
+	recipient := newWallet(keyECDSA)
+	minerAddress := string(miner.getAddress())
+
+	bc := initBlockchainWithGenesis(newMemoryStore(), genesisBlock(newCoinbaseTX(minerAddress, "genesis", 0)))
+	UTXOSet := UTXOSet{bc}
+
+	opts := txOptions{Fee: 1, Selector: coinSelectors[defaultCoinSelector]}
+	plan := planPayment(miner, []payment{{string(recipient.getAddress()), 30}}, opts, &UTXOSet)
+	tx := newPaymentTransaction(miner, plan, opts, &UTXOSet)
+
+	err := acceptToMempool(tx, bc)
+	if err != nil {
+		t.Fatalf("the payment is refused: %s", err)
+	}
+	delete(mempool, hex.EncodeToString(tx.ID))
+
+	_, err = bc.mineBlock([]*Transaction{newCoinbaseTX(minerAddress, "", 1), tx})
+	if err != nil {
+		t.Fatalf("can't mine the payment: %s", err)
+	}
+
+	if height := bc.getBestHeight(); height != 1 {
+		t.Fatalf("the best height is %d, not 1", height)
+	}
+	if balance := balanceOf(UTXOSet, recipient); balance != 30 {
+		t.Errorf("the recipient has %d, not 30", balance)
+	}
+	if balance := balanceOf(UTXOSet, miner); balance != 2*reward-30 {
+		t.Errorf("the miner has %d, not %d", balance, 2*reward-30)
+	}
+
+	_, err = bc.mineBlock([]*Transaction{newCoinbaseTX(minerAddress, "", 0), tx})
+	if err == nil {
+		t.Error("a block spending the payment inputs again is mined")
+	}
+
+	_, err = bc.verifyChain(0, verifyUTXOSet)
+	if err != nil {
+		t.Errorf("verifychain fails: %s", err)
+	}
+}
+
+func TestMemoryStoreDeletedBucket(t *testing.T) {
+	store := newMemoryStore()
+
+	err := store.Update(func(tx StoreTx) error {
+		bucket, err := tx.CreateBucket([]byte("b"))
+		if err != nil {
+			return err
+		}
+
+		err = tx.DeleteBucket([]byte("b"))
+		if err != nil {
+			return err
+		}
+
+		if err := bucket.Put([]byte("k"), []byte("v")); !errors.Is(err, errBucketNotFound) {
+			t.Errorf("a put into a deleted bucket returns %v", err)
+		}
+		if err := bucket.Delete([]byte("k")); !errors.Is(err, errBucketNotFound) {
+			t.Errorf("a delete from a deleted bucket returns %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(func(tx StoreTx) error {
+		if tx.Bucket([]byte("b")) != nil {
+			t.Error("the deleted bucket came back")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func balanceOf(UTXOSet UTXOSet, wallet *Wallet) int {
+	balance := 0
+	for _, out := range UTXOSet.findUTXO(hashPubKey(wallet.PublicKey)) {
+		balance += out.Value
+	}
+
+	return balance
+}
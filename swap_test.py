@@ -0,0 +1,119 @@
+import os
+import re
+import shutil
+import subprocess
+import sys
+import tempfile
+
+# Performs a full atomic swap between a node of the main chain and a node of
+# the alt chain: Alice trades 30 main coins for 50 alt coins with Bob.
+# Run from the repository root: python3 swap_test.py
+
+ROOT = os.path.dirname(os.path.abspath(__file__))
+MAIN_NODE = ("main", 5000)
+ALT_NODE = ("alt", 5001)
+
+
+def run_command(command, node, check=True):
+    chain, node_id = node
+    env = os.environ.copy()
+    env['CHAIN'] = chain
+    env['NODE_ID'] = str(node_id)
+    result = subprocess.run(command, shell=True, capture_output=True, text=True, errors="replace", env=env)
+    print(f"NODE {chain}/{node_id}: {command}")
+    print(result.stdout)
+    if check and result.returncode != 0:
+        print(result.stderr)
+        sys.exit(f"FAIL: {command}")
+    return result
+
+
+def create_wallet(node):
+    output = run_command(f"{BINARY} createwallet", node).stdout
+    return output.strip().split(": ")[-1]
+
+
+def field(output, name):
+    match = re.search(rf"^{name}: (\S+)", output, re.MULTILINE)
+    if not match:
+        sys.exit(f"FAIL: no '{name}' in output")
+    return match.group(1)
+
+
+def balance(node, address):
+    output = run_command(f"{BINARY} getbalance -address {address}", node).stdout
+    return int(output.strip().split(": ")[-1])
+
+
+def expect(condition, message):
+    if not condition:
+        sys.exit(f"FAIL: {message}")
+    print(f"OK: {message}\n")
+
+
+def main():
+    alice_main = create_wallet(MAIN_NODE)
+    bob_main = create_wallet(MAIN_NODE)
+    bob_alt = create_wallet(ALT_NODE)
+    alice_alt = create_wallet(ALT_NODE)
+
+    run_command(f"{BINARY} createblockchain -address {alice_main}", MAIN_NODE)
+    run_command(f"{BINARY} createblockchain -address {bob_alt}", ALT_NODE)
+
+    # Alice locks 30 main coins, refundable by her from height 10
+    out = run_command(f"{BINARY} initiateswap -from {alice_main} -to {bob_main} -amount 30 -locktime 10 -mine", MAIN_NODE).stdout
+    secret = field(out, "Secret")
+    secret_hash = field(out, "Secret hash")
+    main_contract = field(out, "Contract")
+    main_txid = field(out, "Contract transaction")
+
+    # Bob audits Alice's contract before locking his coins
+    out = run_command(f"{BINARY} auditswap -contract {main_contract} -txid {main_txid}", MAIN_NODE).stdout
+    expect(field(out, "Amount") == "30", "main contract locks 30")
+    expect(field(out, "Recipient") == bob_main, "main contract pays Bob")
+    expect(field(out, "Status") == "unspent", "main contract is unspent")
+
+    # Bob locks 50 alt coins with the same secret hash and a shorter lock time
+    out = run_command(f"{BINARY} initiateswap -from {bob_alt} -to {alice_alt} -amount 50 -locktime 5 -secrethash {secret_hash} -mine", ALT_NODE).stdout
+    alt_contract = field(out, "Contract")
+    alt_txid = field(out, "Contract transaction")
+
+    # Refunding before the lock time must be rejected
+    result = run_command(f"{BINARY} refundswap -contract {alt_contract} -txid {alt_txid} -mine", ALT_NODE, check=False)
+    expect(result.returncode != 0 and "locked until height 5" in result.stderr, "early refund is rejected")
+
+    out = run_command(f"{BINARY} auditswap -contract {alt_contract} -txid {alt_txid}", ALT_NODE).stdout
+    expect(field(out, "Amount") == "50", "alt contract locks 50")
+    expect(field(out, "Recipient") == alice_alt, "alt contract pays Alice")
+
+    # Alice redeems on the alt chain, revealing the secret
+    run_command(f"{BINARY} redeemswap -contract {alt_contract} -txid {alt_txid} -secret {secret} -mine", ALT_NODE)
+
+    # Bob learns the secret from the alt chain and redeems on the main chain
+    out = run_command(f"{BINARY} auditswap -contract {alt_contract} -txid {alt_txid}", ALT_NODE).stdout
+    revealed = field(out, "Secret")
+    expect(revealed == secret, "secret is revealed on the alt chain")
+
+    run_command(f"{BINARY} redeemswap -contract {main_contract} -txid {main_txid} -secret {revealed} -mine", MAIN_NODE)
+
+    out = run_command(f"{BINARY} auditswap -contract {main_contract} -txid {main_txid}", MAIN_NODE).stdout
+    expect(field(out, "Status") == "redeemed", "main contract is redeemed")
+
+    # Redeeming mines a block, so each redeemer also holds a 100 coin reward
+    expect(balance(MAIN_NODE, bob_main) == 30 + 100, "Bob received 30 main coins")
+    expect(balance(ALT_NODE, alice_alt) == 50 + 100, "Alice received 50 alt coins")
+    expect(balance(MAIN_NODE, alice_main) == 200 - 30, "Alice spent 30 main coins")
+    expect(balance(ALT_NODE, bob_alt) == 200 - 50, "Bob spent 50 alt coins")
+
+    print("Swap complete.")
+
+
+if __name__ == "__main__":
+    workdir = tempfile.mkdtemp()
+    BINARY = os.path.join(workdir, "blockchain_go")
+    subprocess.run(["go", "build", "-o", BINARY, "."], cwd=ROOT, check=True)
+    os.chdir(workdir)
+    try:
+        main()
+    finally:
+        shutil.rmtree(workdir)
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const lockTimeThreshold = 500000000 // lock times below are block heights, above are unix timestamps
+
+// Relative locks are encoded in TXInput.Sequence: the low 16 bits hold the
+// lock value, counted in blocks or, with the type flag set, in units of 512
+// seconds since the spent output was confirmed. A zero sequence means no lock.
+const (
+	sequenceDisableFlag = uint32(1) << 31
+	sequenceTypeFlag    = uint32(1) << 22
+	sequenceMask        = uint32(0x0000ffff)
+	sequenceGranularity = 9 // time based relative locks are in 2^9 = 512 second units
+)
+
+// relativeLockBlocks returns the sequence value locking an input for the given number of blocks
+func relativeLockBlocks(blocks int) uint32 {
+	return uint32(blocks) & sequenceMask
+}
+
+// relativeLockSeconds returns the sequence value locking an input for at least the given number of seconds
+func relativeLockSeconds(seconds int64) uint32 {
+	units := (seconds + (1 << sequenceGranularity) - 1) >> sequenceGranularity
+	return sequenceTypeFlag | (uint32(units) & sequenceMask)
+}
+
+// isFinal reports whether the absolute lock time of tx allows it in a block at height with blockTime
+func (tx Transaction) isFinal(height int, blockTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	if tx.LockTime < lockTimeThreshold {
+		return int64(height) >= tx.LockTime
+	}
+
+	return blockTime >= tx.LockTime
+}
+
+// checkLocks returns an error if tx cannot be included in a block at height
+// with blockTime because of its absolute lock time or the relative locks of its inputs
+func checkLocks(view coinView, tx *Transaction, height int, blockTime int64) error {
+	if !tx.isFinal(height, blockTime) {
+		if tx.LockTime < lockTimeThreshold {
+			return fmt.Errorf("transaction is locked until height %d", tx.LockTime)
+		}
+		return fmt.Errorf("transaction is locked until %s", time.Unix(tx.LockTime, 0).UTC().Format(time.RFC3339))
+	}
+
+	if tx.isCoinbase() {
+		return nil
+	}
+
+	for inID, vin := range tx.Vin {
+		if vin.Sequence&sequenceDisableFlag != 0 || vin.Sequence&sequenceMask == 0 {
+			continue
+		}
+
+		prevBlock, err := view.outputBlock(vin)
+		if err != nil {
+			return err
+		}
+
+		value := int64(vin.Sequence & sequenceMask)
+		if vin.Sequence&sequenceTypeFlag != 0 {
+			unlockTime := prevBlock.Timestamp + value<<sequenceGranularity
+			if blockTime < unlockTime {
+				return fmt.Errorf("input %d is locked until %s", inID, time.Unix(unlockTime, 0).UTC().Format(time.RFC3339))
+			}
+		} else {
+			unlockHeight := int64(prevBlock.Height) + value
+			if int64(height) < unlockHeight {
+				return fmt.Errorf("input %d is locked until height %d", inID, unlockHeight)
+			}
+		}
+	}
+
+	return nil
+}
@@ -2,24 +2,24 @@ package main
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
-	"math/big"
+	"os"
 	"strings"
 )
 
 const reward = 100 // reward for mining a block
 
 type Transaction struct {
-	ID   []byte
-	Vin  []TXInput
-	Vout []TXOutput
+	ID       []byte
+	Vin      []TXInput
+	Vout     []TXOutput
+	LockTime int64 // height or timestamp before which the transaction can't be mined
+	Version  int   // 0 for transactions whose ID hashes the gob encoding, see txid.go
 }
 
 
@@ -28,21 +28,13 @@ func (tx Transaction) isCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
+// setTXID stamps tx with the current transaction version and sets its ID
 func (tx *Transaction) setTXID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-
-	encoder := gob.NewEncoder(&encoded)
-	err := encoder.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
+	tx.Version = txVersion
+	tx.ID = tx.computeTXID()
 }
 
-func newCoinbaseTX(to, data string) *Transaction {
+func newCoinbaseTX(to, data string, fees int) *Transaction {
 	if data == "" {
 		randomData := make([]byte, 20)		// create a random byte slice of size 20
 		_, err := rand.Read(randomData)
@@ -52,52 +44,156 @@ func newCoinbaseTX(to, data string) *Transaction {
 		data = fmt.Sprintf("%x", randomData)	// convert the byte slice to a string
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := newTXOutput(reward, to)
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
+	txin := TXInput{Txid: []byte{}, Vout: -1, PubKey: []byte(data)}
+	txout := newTXOutput(reward+fees, to)
+	tx := Transaction{Vin: []TXInput{txin}, Vout: []TXOutput{*txout}}
 	tx.setTXID()
 
 	return &tx
 }
 
-func newUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
+// payment is one recipient of a transaction
+type payment struct {
+	Address string
+	Amount  int
+}
 
-	pubKeyHash := hashPubKey(wallet.PublicKey)
+// txOptions tune how a payment transaction is funded and locked
+type txOptions struct {
+	Fee      int
+	LockTime int64
+	Sequence uint32
+	Selector coinSelector
+	Inputs   []outpoint  // spend exactly these outputs instead of selecting coins
+	SigHash  sigHashType // parts of the transaction the signatures commit to, ALL if unset
+}
 
-	acc, validOutputs := UTXOSet.findSpendableOutputs(pubKeyHash, amount)
+// paymentPlan is the funding of a payment transaction before it is signed
+type paymentPlan struct {
+	Inputs   []spendableOutput
+	Outputs  []TXOutput // paid ahead of Payments, such as a contract or a data output
+	Payments []payment
+	Fee      int
+	Change   int
+}
 
-	if acc < amount {
-    	log.Panic("Error: Not enough funds")
+// planPayment selects the wallet outputs funding payments and the fee
+func planPayment(wallet *Wallet, payments []payment, opts txOptions, UTXOSet *UTXOSet) *paymentPlan {
+	return planSpend(hashPubKey(wallet.PublicKey), nil, payments, opts, UTXOSet)
+}
+
+// planSpend selects the outputs locked to pubKeyHash funding outputs,
+// payments and the fee. Change below the dust threshold is left to the miner
+func planSpend(pubKeyHash []byte, outputs []TXOutput, payments []payment, opts txOptions, UTXOSet *UTXOSet) *paymentPlan {
+	amount := opts.Fee
+	for _, out := range outputs {
+		amount += out.Value
+	}
+	for _, p := range payments {
+		amount += p.Amount
 	}
 
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
+	var inputs []spendableOutput
+	acc := 0
+
+	if len(opts.Inputs) > 0 {
+		seen := make(map[string]bool)
+		for _, o := range opts.Inputs {
+			if seen[o.String()] {
+				log.Panic("Error: output ", o, " is listed more than once")
+			}
+			seen[o.String()] = true
+
+			input, err := UTXOSet.getSpendable(o, pubKeyHash)
+			if err != nil {
+				log.Panic("Error: ", err)
+			}
+			inputs = append(inputs, input)
+			acc += input.Value
+		}
+
+		if acc < amount {
+			log.Panic("Error: ", errInsufficientFunds)
+		}
+	} else {
+		selector := opts.Selector
+		if selector == nil {
+			selector = coinSelectors[defaultCoinSelector]
+		}
+
+		target := amount
+		if target < 1 {
+			target = 1 // a transaction spends at least one output, even one paying only a data output
+		}
+
+		selected, err := selector.selectCoins(UTXOSet.findSpendableCandidates(pubKeyHash), target)
 		if err != nil {
-			log.Panic(err)
+			log.Panic("Error: ", err)
 		}
 
-		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
-			inputs = append(inputs, input)
+		for _, in := range selected {
+			acc += in.Value
 		}
+		inputs = selected
+	}
+
+	plan := &paymentPlan{inputs, outputs, payments, opts.Fee, acc - amount}
+	if plan.Change > 0 && plan.Change < standardPolicy.DustThreshold {
+		plan.Fee += plan.Change // dust change is left to the miner
+		plan.Change = 0
+	}
+
+	return plan
+}
+
+// newPaymentTransaction pays every recipient of plan from wallet in one
+// transaction with a single change output and leaves the fee to the miner
+func newPaymentTransaction(wallet *Wallet, plan *paymentPlan, opts txOptions, UTXOSet *UTXOSet) *Transaction {
+	var inputs []TXInput
+	var outputs []TXOutput
+
+	for _, in := range plan.Inputs {
+		input := TXInput{Txid: in.TxID, Vout: in.Vout, PubKey: wallet.PublicKey, Sequence: opts.Sequence}
+		inputs = append(inputs, input)
 	}
 
 	from := fmt.Sprintf("%s", wallet.getAddress())
-	outputs = append(outputs, *newTXOutput(amount, to))
+	outputs = append(outputs, plan.Outputs...)
+	for _, p := range plan.Payments {
+		outputs = append(outputs, *newTXOutput(p.Amount, p.Address))
+	}
 
-	if acc > amount {
-		outputs = append(outputs, *newTXOutput(acc-amount, from))
+	if plan.Change > 0 {
+		outputs = append(outputs, *newTXOutput(plan.Change, from))
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	hashType := opts.SigHash
+	if hashType == 0 {
+		hashType = sigHashAll
+	}
+
+	tx := Transaction{Vin: inputs, Vout: outputs, LockTime: opts.LockTime}
 	tx.setTXID()
-	UTXOSet.blockchain.signTransaction(&tx, *wallet.PrivateKey)
+	UTXOSet.blockchain.signTransaction(&tx, wallet, hashType)
 
 	return &tx
 }
 
+// print shows the inputs chosen by a plan without building the transaction
+func (plan *paymentPlan) print() {
+	total := 0
+	for _, in := range plan.Inputs {
+		fmt.Printf("Input %x:%d value %d\n", in.TxID, in.Vout, in.Value)
+		total += in.Value
+	}
+	for _, p := range plan.Payments {
+		fmt.Printf("Pay %d to %s\n", p.Amount, p.Address)
+	}
+	fmt.Printf("Inputs: %d (total %d)\n", len(plan.Inputs), total)
+	fmt.Printf("Fee: %d\n", plan.Fee)
+	fmt.Printf("Change: %d\n", plan.Change)
+}
+
 
 func (tx Transaction) serialize() []byte {
 	var encoded bytes.Buffer
@@ -117,84 +213,87 @@ func (tx *Transaction) hash() []byte {
 	return hash[:]
 }
 
-func (tx *Transaction) sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+func (tx *Transaction) sign(wallet *Wallet, prevTXs map[string]Transaction, hashType sigHashType) {
 	if tx.isCoinbase() {
 		return
 	}
 
-	txCopy := tx.trimmedCopy()
-
-	for inID, vin := range txCopy.Vin {
-		prevTX := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTX.Vout[vin.Vout].PubKeyHash
-	
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
-		if err != nil {
-			log.Panic(err)
+	for inID, vin := range tx.Vin {
+		prevOut := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout]
+		if prevOut.ScriptType == scriptHash {
+			continue // script hash inputs are signed with signMultisig
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
 
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
+		tx.Vin[inID].Signature = tx.signInput(wallet, inID, prevOut, hashType)
 	}
 }
 
+// legacySignatureData returns the data signed for input inID before sighash
+// flags: a trimmed copy of the transaction in which only that input carries
+// the hash it is unlocking, printed with fmt
+func (tx *Transaction) legacySignatureData(inID int, prevOut TXOutput) []byte {
+	txCopy := tx.trimmedCopy()
+	txCopy.Vin[inID].PubKey = prevOut.PubKeyHash
+
+	return []byte(fmt.Sprintf("%x\n", txCopy))
+}
+
 func (tx *Transaction) trimmedCopy() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{Txid: vin.Txid, Vout: vin.Vout, Sequence: vin.Sequence})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash, vout.ScriptType, vout.Data})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime, tx.Version}
 
 	return txCopy
 }
 
 func (tx *Transaction) verify(prevTXs map[string]Transaction) bool {
+	queue := &sigQueue{}
+
+	return tx.addSignatures(prevTXs, queue) && queue.verify() < 0
+}
+
+// addSignatures evaluates the scripts of the inputs of tx and queues their
+// signature checks on queue, it returns false if a script already fails
+func (tx *Transaction) addSignatures(prevTXs map[string]Transaction, queue *sigQueue) bool {
 	if tx.isCoinbase() {
 		return true
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("Error: Previous transaction is not correct")
-		}
-	}
-
-	txCopy := tx.trimmedCopy()
-	curve := elliptic.P256()
-
 	for inID, vin := range tx.Vin {
 		prevTX := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTX.Vout[vin.Vout].PubKeyHash
-
-		r, s := big.Int{}, big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes([]byte(vin.Signature[:(sigLen / 2)]))
-		s.SetBytes([]byte(vin.Signature[(sigLen / 2):]))
-
-		x, y := big.Int{}, big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes([]byte(vin.PubKey[:(keyLen / 2)]))
-		y.SetBytes([]byte(vin.PubKey[(keyLen / 2):]))
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+		if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			return false
+		}
+		prevOut := prevTX.Vout[vin.Vout]
+		checkSig := func(pubKey, signature []byte) bool {
+			return tx.addInputSignature(queue, inID, prevOut, pubKey, signature)
+		}
 
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if !ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) {
+		switch prevOut.ScriptType {
+		case scriptData:
 			return false
+		case scriptHash:
+			if !verifyMultisigInput(vin, prevOut, checkSig) {
+				return false
+			}
+		case scriptHTLC:
+			if !verifyHTLCInput(tx, vin, prevOut, checkSig) {
+				return false
+			}
+		default:
+			if !vin.usesKey(prevOut.PubKeyHash) || !checkSig(vin.PubKey, vin.Signature) {
+				return false
+			}
 		}
-		txCopy.Vin[inID].PubKey = nil
 	}
 
 	return true
@@ -205,19 +304,46 @@ func (tx Transaction) toString() string{
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	if tx.Version > 0 {
+		lines = append(lines, fmt.Sprintf("     Witness hash: %x", tx.witnessHash()))
+	}
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     Lock time: %d", tx.LockTime))
+	}
 
 	for i, input := range tx.Vin {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
+		if input.Sequence != 0 {
+			lines = append(lines, fmt.Sprintf("       Sequence:  %d", input.Sequence))
+		}
 		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
 		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if len(input.RedeemScript) > 0 {
+			lines = append(lines, fmt.Sprintf("       Script:    %x", input.RedeemScript))
+			for j, sig := range input.Signatures {
+				lines = append(lines, fmt.Sprintf("       Sig %d:     %x", j, sig))
+			}
+		}
+		if len(input.Preimage) > 0 {
+			lines = append(lines, fmt.Sprintf("       Secret:    %x", input.Preimage))
+		}
 	}
 
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
 		lines = append(lines, fmt.Sprintf("       PubKey: %x", output.PubKeyHash))
+		if output.ScriptType == scriptHash {
+			lines = append(lines, "       Type:   script hash")
+		}
+		if output.ScriptType == scriptHTLC {
+			lines = append(lines, "       Type:   hash time-locked contract")
+		}
+		if output.ScriptType == scriptData {
+			lines = append(lines, fmt.Sprintf("       Data:   %x", output.Data))
+		}
 	}
 
 	return strings.Join(lines, "\n")
@@ -233,4 +359,28 @@ func deserializeTransaction(data []byte) Transaction {
 	}
 
 	return transaction
-}
\ No newline at end of file
+}
+
+// saveTransactionFile writes a hex encoded transaction so it can be passed between signers
+func saveTransactionFile(path string, tx *Transaction) {
+	data := []byte(hex.EncodeToString(tx.serialize()))
+
+	err := os.WriteFile(path, data, 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func loadTransactionFile(path string) Transaction {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	txData, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return deserializeTransaction(txData)
+}
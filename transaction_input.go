@@ -3,14 +3,18 @@ package main
 import "bytes"
 
 type TXInput struct {
-	Txid      []byte
-	Vout      int
-	Signature []byte
-	PubKey    []byte
+	Txid         []byte
+	Vout         int
+	Signature    []byte
+	PubKey       []byte
+	RedeemScript []byte   // script revealed when spending a script hash output
+	Signatures   [][]byte // one signature slot per public key of a multisig redeem script
+	Sequence     uint32   // relative lock of the input, see timelock.go
+	Preimage     []byte   // secret revealed when redeeming a hash time-locked output
 }
 
 
 func (in *TXInput) usesKey(pubKeyHash []byte) bool {
 	lockingHash := hashPubKey(in.PubKey)
 	return bytes.Equal(lockingHash, pubKeyHash)
-}
\ No newline at end of file
+}
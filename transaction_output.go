@@ -2,20 +2,30 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
-	"log"
+)
+
+const (
+	scriptPubKeyHash = iota // locked to the hash of a single public key
+	scriptHash              // locked to the hash of a redeem script
+	scriptHTLC              // locked to the hash of a hash time-locked contract
+	scriptData              // unspendable output carrying arbitrary data
 )
 
 type TXOutput struct {
 	Value        int
 	PubKeyHash   []byte
+	ScriptType   int
+	Data         []byte
 }
 
 // Lock signs the output
 func (out *TXOutput) lock(address []byte) {
-	pubKeyHash := base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	version, pubKeyHash := decodeAddress(address)
 	out.PubKeyHash = pubKeyHash
+
+	if version == scriptHashVersion {
+		out.ScriptType = scriptHash
+	}
 }
 
 // IsLockedWithKey checks if the output can be used by the owner of the pubkey
@@ -25,36 +35,8 @@ func (out *TXOutput) isLockedWithKey(pubKeyHash []byte) bool {
 
 // NewTXOutput create a new TXOutput
 func newTXOutput(value int, address string) *TXOutput {
-	txo := &TXOutput{value, nil}
+	txo := &TXOutput{value, nil, scriptPubKeyHash, nil}
 	txo.lock([]byte(address))
 
 	return txo
 }
-
-type TXOutputs struct {
-	Outputs []TXOutput
-}
-
-func (outs TXOutputs) serialize() []byte {
-	var buffer bytes.Buffer
-
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(outs)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return buffer.Bytes()
-}
-
-func deserializeOutputs(data []byte) TXOutputs {
-	var outputs TXOutputs
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&outputs)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return outputs
-}
\ No newline at end of file
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+)
+
+const txIndexBucket = "txindex"
+
+// txIndex maps the ID of every transaction of the active chain to the hash
+// of its block and its position in the block
+type txIndex struct{}
+
+func (txIndex) name() string {
+	return txIndexBucket
+}
+
+func (txIndex) connect(tx StoreTx, b *block) error {
+	bucket := tx.Bucket([]byte(txIndexBucket))
+
+	for pos, transaction := range b.Transactions {
+		var value [4]byte
+		binary.BigEndian.PutUint32(value[:], uint32(pos))
+
+		err := bucket.Put(transaction.ID, append(append([]byte{}, b.Hash...), value[:]...))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (txIndex) disconnect(tx StoreTx, b *block) error {
+	bucket := tx.Bucket([]byte(txIndexBucket))
+
+	for _, transaction := range b.Transactions {
+		err := bucket.Delete(transaction.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var errTxIndexUnavailable = errors.New("the transaction index is not enabled or still being built")
+
+// lookupTxIndex returns the block hash and position of transaction ID
+func (bc *blockchain) lookupTxIndex(ID []byte) ([]byte, int, error) {
+	var blockHash []byte
+	pos := -1
+
+	err := bc.db.View(func(tx StoreTx) error {
+		if !bc.indexSynced(tx, txIndex{}) {
+			return errTxIndexUnavailable
+		}
+
+		value := tx.Bucket([]byte(txIndexBucket)).Get(ID)
+		if value == nil {
+			return errors.New("Transaction is not found")
+		}
+
+		blockHash = append([]byte{}, value[:len(value)-4]...)
+		pos = int(binary.BigEndian.Uint32(value[len(value)-4:]))
+
+		return nil
+	})
+
+	return blockHash, pos, err
+}
+
+// txIndexed reports whether transaction lookups are served by the index
+func (bc *blockchain) txIndexed() bool {
+	synced := false
+
+	err := bc.db.View(func(tx StoreTx) error {
+		synced = bc.indexSynced(tx, txIndex{})
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return synced
+}
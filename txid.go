@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Transactions of version 0 have the hash of their gob encoding as ID.
+// From version 1 the ID only covers the non-witness data: signatures,
+// public keys, redeem scripts and preimages can't change it, so a relayer
+// can't alter the ID of an unconfirmed transaction. The full data is
+// covered by the witness hash, whose Merkle root is committed in the block.
+// From version 2 the signatures also commit to the version, so a
+// transaction can't be turned into another version with the same inputs.
+//
+// The gob encoding depends on the type IDs of the process that wrote it, so
+// version 0 IDs can't be checked. Version 0 transactions are only valid in
+// blocks before version 2, and a block can't have a lower version than the
+// block before it
+const txVersion = 2
+
+// computeTXID returns the ID of a transaction of version 1 on
+func (tx *Transaction) computeTXID() []byte {
+	var buf bytes.Buffer
+	tx.writeNonWitness(&buf)
+
+	return doubleSHA256(buf.Bytes())
+}
+
+// witnessHash returns the hash of the whole transaction including its witness data
+func (tx *Transaction) witnessHash() []byte {
+	var buf bytes.Buffer
+	tx.writeNonWitness(&buf)
+
+	for _, vin := range tx.Vin {
+		writeBytes(&buf, vin.Signature)
+		writeBytes(&buf, vin.PubKey)
+		writeBytes(&buf, vin.RedeemScript)
+		writeUint64(&buf, uint64(len(vin.Signatures)))
+		for _, signature := range vin.Signatures {
+			writeBytes(&buf, signature)
+		}
+		writeBytes(&buf, vin.Preimage)
+	}
+
+	return doubleSHA256(buf.Bytes())
+}
+
+func (tx *Transaction) writeNonWitness(buf *bytes.Buffer) {
+	writeUint64(buf, uint64(tx.Version))
+	writeUint64(buf, uint64(tx.LockTime))
+
+	writeUint64(buf, uint64(len(tx.Vin)))
+	for _, vin := range tx.Vin {
+		writeBytes(buf, vin.Txid)
+		writeUint64(buf, uint64(vin.Vout))
+		writeUint64(buf, uint64(vin.Sequence))
+		if tx.isCoinbase() {
+			writeBytes(buf, vin.PubKey) // coinbase data keeps coinbase IDs unique
+		}
+	}
+
+	writeUint64(buf, uint64(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		writeSigHashOutput(buf, out)
+	}
+}
+
+func doubleSHA256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const defaultUTXOCacheMB = 32
+const utxoFlushBatch = 10000      // UTXO entries written per transaction when flushing
+const utxoCacheEntryOverhead = 96 // rough bytes of map and slice headers per cached entry
+
+var utxoCacheBudget = defaultUTXOCacheMB << 20
+
+// utxoCacheStore wraps a ChainStore and keeps the writes to the UTXO set and
+// to its best-block marker in memory, so connecting a block doesn't write
+// its UTXO changes to disk. Reads see the cached entries over the stored
+// ones. The cache is flushed in batches once it outgrows its budget and
+// when the store is closed; a crash loses the unflushed blocks, which
+// checkChainstate connects again from the marker on disk.
+//
+// This gives up writing a block's UTXO changes in the same transaction as
+// the block and the tip: the UTXO set on disk may lag the tip, and only the
+// replay at startup brings it back in step
+type utxoCacheStore struct {
+	ChainStore
+	writer sync.Mutex // write transactions and flushes, one at a time
+
+	mu        sync.RWMutex      // guards the fields below
+	entries   map[string][]byte // a nil value is a deleted entry
+	marker    []byte
+	markerSet bool
+	size      int
+	budget    int
+}
+
+func newUTXOCacheStore(store ChainStore, budget int) *utxoCacheStore {
+	return &utxoCacheStore{ChainStore: store, entries: make(map[string][]byte), budget: budget}
+}
+
+func (s *utxoCacheStore) View(fn func(tx StoreTx) error) error {
+	return s.ChainStore.View(func(tx StoreTx) error {
+		return fn(s.newTx(tx, false))
+	})
+}
+
+func (s *utxoCacheStore) Update(fn func(tx StoreTx) error) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+
+	var cached *utxoCacheTx
+	err := s.ChainStore.Update(func(tx StoreTx) error {
+		cached = s.newTx(tx, true)
+		return fn(cached)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.commit(cached)
+
+	// the write is committed, so a failed flush doesn't fail it: the
+	// entries stay cached for the next flush, and a crash before it is
+	// repaired by checkChainstate
+	if s.size > s.budget {
+		err = s.flush()
+		if err != nil {
+			fmt.Printf("Can't flush the UTXO cache: %s\n", err)
+		}
+	}
+
+	return nil
+}
+
+// Close flushes the cache and closes the wrapped store
+func (s *utxoCacheStore) Close() error {
+	err := s.flushCache()
+	if err != nil {
+		return err
+	}
+
+	return s.ChainStore.Close()
+}
+
+// flushCache writes the cached UTXO changes to the wrapped store now
+func (s *utxoCacheStore) flushCache() error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+
+	return s.flush()
+}
+
+// backup flushes the cache and backs up the wrapped store. It returns an
+// empty path if that store can't be backed up
+func (s *utxoCacheStore) backup(version int) (string, error) {
+	err := s.flushCache()
+	if err != nil {
+		return "", err
+	}
+
+	store, ok := s.ChainStore.(storeBackup)
+	if !ok {
+		return "", nil
+	}
+
+	return store.backup(version)
+}
+
+// compact flushes the cache and compacts the wrapped store if it can be
+func (s *utxoCacheStore) compact() (int64, int64, error) {
+	err := s.flushCache()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	store, ok := s.ChainStore.(storeCompactor)
+	if !ok {
+		return 0, 0, nil
+	}
+
+	return store.compact()
+}
+
+func (s *utxoCacheStore) newTx(tx StoreTx, writable bool) *utxoCacheTx {
+	s.mu.RLock()
+	entries := s.entries
+	s.mu.RUnlock()
+
+	return &utxoCacheTx{StoreTx: tx, store: s, entries: entries, writes: make(map[string][]byte), writable: writable}
+}
+
+// commit merges the writes of a committed transaction into the cache
+func (s *utxoCacheStore) commit(tx *utxoCacheTx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tx.cleared {
+		s.entries = make(map[string][]byte)
+		s.marker, s.markerSet = nil, false
+		s.size = 0
+	}
+
+	for key, value := range tx.writes {
+		if old, ok := s.entries[key]; ok {
+			s.size -= len(key) + len(old) + utxoCacheEntryOverhead
+		}
+		s.entries[key] = value
+		s.size += len(key) + len(value) + utxoCacheEntryOverhead
+	}
+
+	if tx.markerSet {
+		s.marker, s.markerSet = tx.marker, true
+	}
+}
+
+// flush writes the cached entries to the wrapped store. The marker on disk
+// is removed by the first batch and written by the last, so a flush cut
+// short leaves a UTXO set that checkChainstate rebuilds
+func (s *utxoCacheStore) flush() error {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	s.mu.RUnlock()
+
+	if len(keys) == 0 && !s.markerSet {
+		return nil
+	}
+	sort.Strings(keys)
+
+	var diskMarker []byte
+	for start := 0; start == 0 || start < len(keys); start += utxoFlushBatch {
+		end := start + utxoFlushBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		err := s.ChainStore.Update(func(tx StoreTx) error {
+			state, err := tx.CreateBucketIfNotExists([]byte(indexStateBucket))
+			if err != nil {
+				return err
+			}
+			if start == 0 {
+				diskMarker = append([]byte{}, state.Get([]byte(utxoBucket))...)
+				err = state.Delete([]byte(utxoBucket))
+				if err != nil {
+					return err
+				}
+			}
+
+			bucket, err := tx.CreateBucketIfNotExists([]byte(utxoBucket))
+			if err != nil {
+				return err
+			}
+			for _, key := range keys[start:end] {
+				if value := s.entries[key]; value != nil {
+					err = bucket.Put([]byte(key), value)
+				} else {
+					err = bucket.Delete([]byte(key))
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			if end < len(keys) {
+				return nil
+			}
+
+			marker := diskMarker
+			if s.markerSet {
+				marker = s.marker
+			}
+			if len(marker) == 0 {
+				return nil
+			}
+			return state.Put([]byte(utxoBucket), marker)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.entries = make(map[string][]byte)
+	s.marker, s.markerSet = nil, false
+	s.size = 0
+	s.mu.Unlock()
+
+	return nil
+}
+
+// utxoCacheTx is a transaction of the wrapped store seeing the UTXO set
+// through the cache. Its own writes to the set stay in writes until the
+// transaction commits
+type utxoCacheTx struct {
+	StoreTx
+	store     *utxoCacheStore
+	entries   map[string][]byte // the cache when the transaction began
+	writes    map[string][]byte
+	cleared   bool // the UTXO bucket was deleted, hiding the cache
+	marker    []byte
+	markerSet bool
+	writable  bool
+}
+
+func (t *utxoCacheTx) Bucket(name []byte) StoreBucket {
+	return t.wrap(name, t.StoreTx.Bucket(name))
+}
+
+func (t *utxoCacheTx) CreateBucket(name []byte) (StoreBucket, error) {
+	bucket, err := t.StoreTx.CreateBucket(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.wrap(name, bucket), nil
+}
+
+func (t *utxoCacheTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	bucket, err := t.StoreTx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.wrap(name, bucket), nil
+}
+
+// DeleteBucket of the UTXO set also drops the cache and the marker on disk
+func (t *utxoCacheTx) DeleteBucket(name []byte) error {
+	err := t.StoreTx.DeleteBucket(name)
+	if err != nil || string(name) != utxoBucket {
+		return err
+	}
+
+	t.cleared = true
+	t.writes = make(map[string][]byte)
+	t.marker, t.markerSet = nil, false
+
+	if state := t.StoreTx.Bucket([]byte(indexStateBucket)); state != nil {
+		return state.Delete([]byte(utxoBucket))
+	}
+
+	return nil
+}
+
+func (t *utxoCacheTx) wrap(name []byte, bucket StoreBucket) StoreBucket {
+	if bucket == nil {
+		return nil
+	}
+
+	switch string(name) {
+	case utxoBucket:
+		return utxoCacheBucket{bucket, t}
+	case indexStateBucket:
+		return markerBucket{bucket, t}
+	}
+
+	return bucket
+}
+
+// cached returns the value of key in the cache, with ok false if the cache doesn't hold it
+func (t *utxoCacheTx) cached(key []byte) ([]byte, bool) {
+	if value, ok := t.writes[string(key)]; ok {
+		return value, true
+	}
+	if t.cleared {
+		return nil, false
+	}
+
+	t.store.mu.RLock()
+	value, ok := t.entries[string(key)]
+	t.store.mu.RUnlock()
+
+	return value, ok
+}
+
+type utxoCacheBucket struct {
+	StoreBucket
+	tx *utxoCacheTx
+}
+
+func (b utxoCacheBucket) Get(key []byte) []byte {
+	if value, ok := b.tx.cached(key); ok {
+		return value
+	}
+
+	return b.StoreBucket.Get(key)
+}
+
+func (b utxoCacheBucket) Put(key, value []byte) error {
+	if !b.tx.writable {
+		return errTxNotWritable
+	}
+
+	b.tx.writes[string(key)] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (b utxoCacheBucket) Delete(key []byte) error {
+	if !b.tx.writable {
+		return errTxNotWritable
+	}
+
+	b.tx.writes[string(key)] = nil
+
+	return nil
+}
+
+func (b utxoCacheBucket) ForEach(fn func(k, v []byte) error) error {
+	cursor := b.Cursor()
+
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		err := fn(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Cursor walks the union of the stored and the cached keys
+func (b utxoCacheBucket) Cursor() StoreCursor {
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	cursor := b.StoreBucket.Cursor()
+	for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+		add(string(k))
+	}
+	if !b.tx.cleared {
+		b.tx.store.mu.RLock()
+		for key := range b.tx.entries {
+			add(key)
+		}
+		b.tx.store.mu.RUnlock()
+	}
+	for key := range b.tx.writes {
+		add(key)
+	}
+	sort.Strings(keys)
+
+	return &keysCursor{keys: keys, get: b.Get}
+}
+
+// markerBucket is the index state bucket with the best-block marker of the UTXO set read and written through the cache
+type markerBucket struct {
+	StoreBucket
+	tx *utxoCacheTx
+}
+
+func (b markerBucket) Get(key []byte) []byte {
+	if string(key) != utxoBucket {
+		return b.StoreBucket.Get(key)
+	}
+
+	if b.tx.markerSet {
+		return b.tx.marker
+	}
+	if !b.tx.cleared {
+		b.tx.store.mu.RLock()
+		marker, markerSet := b.tx.store.marker, b.tx.store.markerSet
+		b.tx.store.mu.RUnlock()
+		if markerSet {
+			return marker
+		}
+	}
+
+	return b.StoreBucket.Get(key)
+}
+
+func (b markerBucket) Put(key, value []byte) error {
+	if string(key) != utxoBucket || !b.tx.writable {
+		return b.StoreBucket.Put(key, value)
+	}
+
+	b.tx.marker, b.tx.markerSet = append([]byte{}, value...), true
+
+	return nil
+}
+
+func (b markerBucket) Delete(key []byte) error {
+	if string(key) != utxoBucket || !b.tx.writable {
+		return b.StoreBucket.Delete(key)
+	}
+
+	b.tx.marker, b.tx.markerSet = nil, true
+
+	return nil
+}
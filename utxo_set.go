@@ -1,8 +1,10 @@
 package main
 
 import (
-	"encoding/hex"
-	"github.com/boltdb/bolt"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
 	"log"
 )
 
@@ -12,52 +14,42 @@ type UTXOSet struct {
 	blockchain *blockchain
 }
 
-func (u UTXOSet) findSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-	accumulated := 0
-	db := u.blockchain.db
-
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucket))
-		cursor := bucket.Cursor()
-
-		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			txID := hex.EncodeToString(k)
-			outs := deserializeOutputs(v)
+// utxoEntry is the record of one unspent output, keyed by its outpoint
+type utxoEntry struct {
+	Output   TXOutput
+	Height   int  // height of the block that created the output
+	Coinbase bool // the output is a block reward
+}
 
-			for outIdx, out := range outs.Outputs {
-				if out.isLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
-				}
-			}
-		}
+func (e utxoEntry) serialize() []byte {
+	return gobEncode(e)
+}
 
-		return nil
-	})
+func deserializeUTXOEntry(data []byte) utxoEntry {
+	entry, err := decodeUTXOEntry(data)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return accumulated, unspentOutputs
+	return entry
 }
 
-func (u UTXOSet) findUTXO(pubKeyHash []byte) []TXOutput { // find all unspent transaction outputs that belong to a public key hash
-	var UTXOs []TXOutput
-	db := u.blockchain.db
+// decodeUTXOEntry is deserializeUTXOEntry for data that may be corrupt
+func decodeUTXOEntry(data []byte) (utxoEntry, error) {
+	var entry utxoEntry
 
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucket))
-		cursor := bucket.Cursor()
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry)
 
-		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			outs := deserializeOutputs(v)
+	return entry, err
+}
 
-			for _, out := range outs.Outputs {
-				if out.isLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
-			}
+// forEachUTXO calls fn with every unspent output in outpoint order
+func (u UTXOSet) forEachUTXO(fn func(o outpoint, entry utxoEntry)) {
+	err := u.blockchain.db.View(func(tx StoreTx) error {
+		cursor := tx.Bucket([]byte(utxoBucket)).Cursor()
+
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			fn(outpointFromKey(k), deserializeUTXOEntry(v))
 		}
 
 		return nil
@@ -65,20 +57,70 @@ func (u UTXOSet) findUTXO(pubKeyHash []byte) []TXOutput { // find all unspent tr
 	if err != nil {
 		log.Panic(err)
 	}
+}
+
+func (u UTXOSet) findSpendableCandidates(pubKeyHash []byte) []spendableOutput { // list the outputs a coin selector can choose from
+	var candidates []spendableOutput
+	locked := u.lockedOutpoints()
+
+	for _, candidate := range u.listUnspent(pubKeyHash) {
+		if !locked[string(outpoint{candidate.TxID, candidate.Vout}.key())] {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	return candidates
+}
+
+func (u UTXOSet) listUnspent(pubKeyHash []byte) []spendableOutput { // list all unspent outputs locked with a public key hash
+	var candidates []spendableOutput
+
+	u.forEachUTXO(func(o outpoint, entry utxoEntry) {
+		if entry.Output.isLockedWithKey(pubKeyHash) {
+			candidates = append(candidates, spendableOutput{TxID: o.TxID, Vout: o.Vout, Value: entry.Output.Value, Height: entry.Height})
+		}
+	})
+
+	return candidates
+}
+
+func (u UTXOSet) findUTXO(pubKeyHash []byte) []TXOutput { // find all unspent transaction outputs that belong to a public key hash
+	var UTXOs []TXOutput
+
+	u.forEachUTXO(func(o outpoint, entry utxoEntry) {
+		if entry.Output.isLockedWithKey(pubKeyHash) {
+			UTXOs = append(UTXOs, entry.Output)
+		}
+	})
 
 	return UTXOs
 }
 
-func (u UTXOSet) countTransactions() int { // count the number of transactions in the UTXO set
-	db := u.blockchain.db
+func (u UTXOSet) countTransactions() int { // count the number of transactions with unspent outputs
 	counter := 0
+	var lastTxID []byte
 
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucket))
-		cursor := bucket.Cursor()
-
-		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+	u.forEachUTXO(func(o outpoint, entry utxoEntry) {
+		if !bytes.Equal(o.TxID, lastTxID) {
 			counter++
+			lastTxID = o.TxID
+		}
+	})
+
+	return counter
+}
+
+// unspentTransaction rebuilds the outputs of txid still in the UTXO set,
+// at their indexes, for signing and verifying the inputs spending them
+// when the transaction itself was pruned. The spent outputs are left empty
+func (u UTXOSet) unspentTransaction(txid []byte) (Transaction, bool) {
+	tx := Transaction{ID: txid}
+
+	err := u.blockchain.db.View(func(dbTx StoreTx) error {
+		cursor := dbTx.Bucket([]byte(utxoBucket)).Cursor()
+
+		for k, v := cursor.Seek(txid); k != nil && bytes.HasPrefix(k, txid) && len(k) == len(txid)+4; k, v = cursor.Next() {
+			tx = withOutput(tx, outpointFromKey(k).Vout, deserializeUTXOEntry(v).Output)
 		}
 
 		return nil
@@ -87,101 +129,301 @@ func (u UTXOSet) countTransactions() int { // count the number of transactions i
 		log.Panic(err)
 	}
 
-	return counter
+	return tx, len(tx.Vout) > 0
+}
+
+// withOutput returns tx with out at index vout, padding the outputs before it
+func withOutput(tx Transaction, vout int, out TXOutput) Transaction {
+	for len(tx.Vout) <= vout {
+		tx.Vout = append(tx.Vout, TXOutput{})
+	}
+	tx.Vout[vout] = out
+
+	return tx
 }
 
 func (u UTXOSet) reindex() { // rebuild the UTXO set
-	db := u.blockchain.db
 	bucketName := []byte(utxoBucket)
 
-	err := db.Update(func(tx *bolt.Tx) error {
+	if height := u.blockchain.pruneHeight(); height > 0 {
+		log.Panicf("ERROR: Can't rebuild the UTXO set, the blocks below height %d are pruned. Delete the database and sync again", height)
+	}
+
+	tip := u.blockchain.tip
+	UTXO := u.blockchain.findUTXO()
+
+	err := u.blockchain.db.Update(func(tx StoreTx) error {
 		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
+		if err != nil && err != errBucketNotFound {
+			return err
 		}
 
-		_, err = tx.CreateBucket(bucketName)
+		bucket, err := tx.CreateBucket(bucketName)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		return nil
+		for key, entry := range UTXO {
+			err = bucket.Put([]byte(key), entry.serialize())
+			if err != nil {
+				return err
+			}
+		}
+
+		return setIndexTip(tx, chainstate{}, tip)
 	})
 	if err != nil {
 		log.Panic(err)
 	}
+}
 
-	UTXO := u.blockchain.findUTXO()
+const undoBucket = "undo" // block hash -> UTXO entries spent by the block
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(bucketName)
+// chainstate connects blocks to the UTXO set the way the chain indexes are
+// connected, so a block, the tip and the UTXO changes are committed together.
+// Its index tip is the best-block marker of the UTXO set
+type chainstate struct{}
 
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				log.Panic(err)
+// blockUndo holds the entries spent by the inputs of a block, in input order
+type blockUndo struct {
+	Spent []utxoEntry
+}
+
+func (chainstate) name() string {
+	return utxoBucket
+}
+
+// connect validates the transactions of b against the UTXO set, which is at
+// the previous block, before applying them
+func (chainstate) connect(tx StoreTx, b *block) error {
+	if b.Pruned {
+		return fmt.Errorf("block %x is pruned", b.Hash)
+	}
+
+	bucket := tx.Bucket([]byte(utxoBucket))
+
+	err := checkBlockVersion(b, blockInTx(tx, b.PrevBlockHash), metaInt(tx, versionHeightKey))
+	if err == nil {
+		err = checkBlockTransactions(utxoView{tx, bucket, b.PrevBlockHash}, b.Transactions, b.Height, b.Timestamp, b.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("block %x at height %d: %w", b.Hash, b.Height, err)
+	}
+
+	undo, err := connectUTXOs(bucket, b)
+	if err != nil {
+		return err
+	}
+
+	undoData, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+
+	return undoData.Put(b.Hash, gobEncode(undo))
+}
+
+// disconnect rolls the UTXO set back over b with its undo data, which is
+// then deleted
+func (chainstate) disconnect(tx StoreTx, b *block) error {
+	undo, err := undoInTx(tx, b.Hash)
+	if err != nil {
+		return err
+	}
+	if undo == nil {
+		return fmt.Errorf("no undo data for block %x", b.Hash)
+	}
+
+	err = disconnectUTXOs(tx.Bucket([]byte(utxoBucket)), b, *undo)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(undoBucket)).Delete(b.Hash)
+}
+
+// utxoEntries holds UTXO entries keyed by outpoint: a bucket of the store,
+// or a copy of one changed in memory
+type utxoEntries interface {
+	Get(key []byte) []byte // nil if the output is spent or never existed
+	Put(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// connectUTXOs spends the outputs the inputs of b spend and adds the
+// outputs it creates, returning the spent entries as its undo data
+func connectUTXOs(utxos utxoEntries, b *block) (blockUndo, error) {
+	undo := blockUndo{}
+
+	for _, tx := range b.Transactions {
+		if tx.isCoinbase() == false {
+			for _, vin := range tx.Vin {
+				key := outpoint{vin.Txid, vin.Vout}.key()
+
+				data := utxos.Get(key)
+				if data == nil {
+					return undo, fmt.Errorf("block %x spends %x:%d which is not in the UTXO set", b.Hash, vin.Txid, vin.Vout)
+				}
+				undo.Spent = append(undo.Spent, deserializeUTXOEntry(data))
+
+				err := utxos.Delete(key)
+				if err != nil {
+					return undo, err
+				}
+			}
+		}
+
+		for outIdx, out := range tx.Vout {
+			if out.isUnspendable() {
+				continue
 			}
 
-			err = bucket.Put(key, outs.serialize())
+			entry := utxoEntry{out, b.Height, tx.isCoinbase()}
+			err := utxos.Put(outpoint{tx.ID, outIdx}.key(), entry.serialize())
 			if err != nil {
-				log.Panic(err)
+				return undo, err
 			}
 		}
-
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
 	}
+
+	return undo, nil
 }
 
-func (u UTXOSet) update(block *block) { // update the UTXO set with the transactions in the block
-	db := u.blockchain.db
+// disconnectUTXOs removes the outputs created by b and restores those it
+// spent from its undo data, going through the transactions backwards for
+// the outputs spent in the block that created them
+func disconnectUTXOs(utxos utxoEntries, b *block, undo blockUndo) error {
+	spent := len(undo.Spent)
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucket))
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		transaction := b.Transactions[i]
 
-		for _, tx := range block.Transactions {
-			if tx.isCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes := bucket.Get(vin.Txid)
-					outs := deserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					if len(updatedOuts.Outputs) == 0 {
-						err := bucket.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
-					} else {
-						err := bucket.Put(vin.Txid, updatedOuts.serialize())
-						if err != nil {
-							log.Panic(err)
-						}
-					}
-				}
+		for outIdx := range transaction.Vout {
+			err := utxos.Delete(outpoint{transaction.ID, outIdx}.key())
+			if err != nil {
+				return err
 			}
+		}
 
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+		if transaction.isCoinbase() {
+			continue
+		}
+
+		for j := len(transaction.Vin) - 1; j >= 0; j-- {
+			spent--
+			if spent < 0 {
+				return fmt.Errorf("undo data of block %x is short", b.Hash)
 			}
 
-			err := bucket.Put(tx.ID, newOutputs.serialize())
+			vin := transaction.Vin[j]
+			err := utxos.Put(outpoint{vin.Txid, vin.Vout}.key(), undo.Spent[spent].serialize())
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
+	}
+
+	return nil
+}
+
+// undoInTx returns the undo data recorded for the block with hash, nil if there is none
+func undoInTx(tx StoreTx, hash []byte) (*blockUndo, error) {
+	bucket := tx.Bucket([]byte(undoBucket))
+	if bucket == nil {
+		return nil, nil
+	}
+
+	data := bucket.Get(hash)
+	if data == nil {
+		return nil, nil
+	}
+
+	var undo blockUndo
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo)
+	if err != nil {
+		return nil, fmt.Errorf("the undo data of block %x is corrupt: %s", hash, err)
+	}
+
+	return &undo, nil
+}
+
+// canDisconnect reports whether undo data was recorded for b, which isn't
+// the case for blocks connected before it was or by a reindex
+func (chainstate) canDisconnect(tx StoreTx, b *block) bool {
+	bucket := tx.Bucket([]byte(undoBucket))
+
+	return bucket != nil && bucket.Get(b.Hash) != nil
+}
+
+// migrateChainstate rebuilds a UTXO set stored in the layout with one
+// record of the remaining outputs per transaction, keyed by txid. Those
+// records lost the output positions, so the set is rebuilt from the blocks
+// and the undo data recorded in that layout is dropped
+func (bc *blockchain) migrateChainstate() {
+	legacy := false
+
+	err := bc.db.View(func(tx StoreTx) error {
+		bucket := tx.Bucket([]byte(utxoBucket))
+		if bucket == nil {
+			return nil
+		}
+
+		k, _ := bucket.Cursor().First()
+		legacy = len(k) == sha256.Size
 
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
+
+	if !legacy {
+		return
+	}
+
+	fmt.Println("Migrating the UTXO set to one record per output")
+
+	err = bc.db.Update(func(tx StoreTx) error {
+		err := tx.DeleteBucket([]byte(undoBucket))
+		if err == errBucketNotFound {
+			return nil
+		}
+
+		return err
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	UTXOSet{bc}.reindex()
+}
+
+// checkChainstate repairs a UTXO set whose best-block marker isn't the tip,
+// as left by a crash or by a chain created before the marker existed. The
+// set is moved to the tip with undo data if it can be, else rebuilt
+func (bc *blockchain) checkChainstate() {
+	moved := false
+
+	err := bc.db.Update(func(tx StoreTx) error {
+		marker, enabled := indexTip(tx, chainstate{})
+		if enabled && bytes.Equal(marker, bc.tip) {
+			moved = true
+			return nil
+		}
+		if !enabled || marker == nil {
+			return nil
+		}
+
+		var err error
+		moved, err = moveIndex(tx, chainstate{}, marker, bc.tip)
+		return err
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if !moved {
+		fmt.Println("The UTXO set is not in step with the tip, rebuilding it")
+		UTXOSet{bc}.reindex()
+	}
 }
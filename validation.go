@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const maxMoney = 1000000000000000 // no amount or sum of amounts may exceed this
+
+const (
+	medianTimeSpan     = 11          // number of blocks whose median timestamp a new block must exceed
+	maxFutureBlockTime = 2 * 60 * 60 // seconds a block timestamp may be ahead of the local clock
+)
+
+// rejectError explains why a transaction was refused, Code is a short
+// machine readable reason and Reason the details
+type rejectError struct {
+	Code   string
+	Reason string
+}
+
+func (e *rejectError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+}
+
+func reject(code string, format string, args ...interface{}) error {
+	return &rejectError{code, fmt.Sprintf(format, args...)}
+}
+
+// checkSanity applies the consensus rules that need no chain context
+func (tx *Transaction) checkSanity() error {
+	if tx.Version > 0 && !bytes.Equal(tx.ID, tx.computeTXID()) {
+		return reject("bad-txns-txid", "ID %x does not match the transaction data", tx.ID)
+	}
+
+	if len(tx.Vin) == 0 {
+		return reject("bad-txns-vin-empty", "transaction has no inputs")
+	}
+
+	if len(tx.Vout) == 0 {
+		return reject("bad-txns-vout-empty", "transaction has no outputs")
+	}
+
+	total := 0
+	for i, out := range tx.Vout {
+		if out.Value < 0 {
+			return reject("bad-txns-vout-negative", "output %d has negative value %d", i, out.Value)
+		}
+		if out.Value > maxMoney {
+			return reject("bad-txns-vout-toolarge", "output %d value %d exceeds %d", i, out.Value, maxMoney)
+		}
+		total += out.Value
+		if total > maxMoney {
+			return reject("bad-txns-txouttotal-toolarge", "total output value exceeds %d", maxMoney)
+		}
+	}
+
+	if !tx.checkDataOutputs() {
+		return reject("bad-txns-data-output", "data-carrier outputs must have zero value and 1 to %d bytes", maxDataCarrierSize)
+	}
+
+	if tx.isCoinbase() {
+		return nil
+	}
+
+	spent := make(map[string]bool)
+	for i, vin := range tx.Vin {
+		key := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+		if spent[key] {
+			return reject("bad-txns-inputs-duplicate", "input %d spends %s twice", i, key)
+		}
+		spent[key] = true
+	}
+
+	return nil
+}
+
+// checkInputValues ensures every input refers to an existing output and
+// that the inputs cover the outputs, and returns the fee they leave
+func (tx *Transaction) checkInputValues(prevTXs map[string]Transaction) (int, error) {
+	totalIn := 0
+	for i, vin := range tx.Vin {
+		prevTX := prevTXs[hex.EncodeToString(vin.Txid)]
+		if vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			return 0, reject("bad-txns-inputs-missing", "input %d spends a missing output %x:%d", i, vin.Txid, vin.Vout)
+		}
+
+		totalIn += prevTX.Vout[vin.Vout].Value
+		if totalIn > maxMoney {
+			return 0, reject("bad-txns-inputvalues-outofrange", "total input value exceeds %d", maxMoney)
+		}
+	}
+
+	totalOut := 0
+	for _, out := range tx.Vout {
+		totalOut += out.Value
+	}
+
+	if totalIn < totalOut {
+		return 0, reject("bad-txns-in-belowout", "inputs %d are less than outputs %d", totalIn, totalOut)
+	}
+
+	return totalIn - totalOut, nil
+}
+
+// coinView looks up the outputs spent by the transactions being validated
+type coinView interface {
+	// prevTransactions returns the transactions whose outputs tx spends,
+	// holding at least those outputs
+	prevTransactions(tx *Transaction) (map[string]Transaction, error)
+	// outputBlock returns the block that created the output spent by vin
+	outputBlock(vin TXInput) (*block, error)
+}
+
+// utxoView finds the spent outputs in a UTXO set, so an input spending an
+// output that is spent or never existed is refused
+type utxoView struct {
+	tx    StoreTx
+	utxos utxoEntries // the UTXO set
+	tip   []byte      // block the UTXO set is at
+}
+
+// tipView returns the view of the UTXO set at the chain tip
+func (bc *blockchain) tipView(tx StoreTx) (utxoView, error) {
+	if !bc.indexSynced(tx, chainstate{}) {
+		return utxoView{}, errors.New("the UTXO set is not in step with the tip")
+	}
+
+	return utxoView{tx, tx.Bucket([]byte(utxoBucket)), tipInTx(tx)}, nil
+}
+
+func (v utxoView) entry(vin TXInput) (utxoEntry, error) {
+	data := v.utxos.Get(outpoint{vin.Txid, vin.Vout}.key())
+	if data == nil {
+		return utxoEntry{}, reject("bad-txns-inputs-missingorspent", "output %x:%d is missing or spent", vin.Txid, vin.Vout)
+	}
+
+	return deserializeUTXOEntry(data), nil
+}
+
+func (v utxoView) prevTransactions(tx *Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+
+	for _, vin := range tx.Vin {
+		entry, err := v.entry(vin)
+		if err != nil {
+			return nil, err
+		}
+
+		id := hex.EncodeToString(vin.Txid)
+		prevTX := prevTXs[id]
+		prevTX.ID = vin.Txid
+		prevTXs[id] = withOutput(prevTX, vin.Vout, entry.Output)
+	}
+
+	return prevTXs, nil
+}
+
+// outputBlock walks back from the tip to the height recorded with the output
+func (v utxoView) outputBlock(vin TXInput) (*block, error) {
+	entry, err := v.entry(vin)
+	if err != nil {
+		return nil, err
+	}
+
+	b := blockInTx(v.tx, v.tip)
+	for b != nil && b.Height > entry.Height {
+		b = blockInTx(v.tx, b.PrevBlockHash)
+	}
+	if b == nil {
+		return nil, fmt.Errorf("the block at height %d is missing", entry.Height)
+	}
+
+	return b, nil
+}
+
+// validateTransaction applies the consensus rules for including tx in a block at height with blockTime
+func (bc *blockchain) validateTransaction(tx *Transaction, height int, blockTime int64) error {
+	err := checkTransactionVersions([]*Transaction{tx}, blockVersion)
+	if err != nil {
+		return err
+	}
+
+	queue := &sigQueue{}
+	err = bc.db.View(func(dbTx StoreTx) error {
+		view, err := bc.tipView(dbTx)
+		if err != nil {
+			return err
+		}
+
+		_, err = checkTransaction(view, tx, height, blockTime, queue)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if queue.verify() >= 0 {
+		return reject("bad-txns-signature", "input signatures do not verify")
+	}
+
+	return nil
+}
+
+// validateBlockTransactions applies the consensus rules to the transactions
+// of a block to be mined on top of the tip
+func (bc *blockchain) validateBlockTransactions(txs []*Transaction, height int, blockTime int64) error {
+	err := checkTransactionVersions(txs, blockVersion)
+	if err != nil {
+		return err
+	}
+
+	return bc.db.View(func(tx StoreTx) error {
+		view, err := bc.tipView(tx)
+		if err != nil {
+			return err
+		}
+
+		return checkBlockTransactions(view, txs, height, blockTime, blockVersion)
+	})
+}
+
+// checkBlockVersion checks the version of b against the block before it,
+// nil for the genesis block, and the versions of its transactions. From
+// versionHeight on blocks must have blockVersion, see migrateVersionHeight
+func checkBlockVersion(b, parent *block, versionHeight int) error {
+	if b.Height >= versionHeight && b.Version < blockVersion {
+		return reject("bad-version", "block version %d is below %d, required from height %d", b.Version, blockVersion, versionHeight)
+	}
+	if parent != nil && b.Version < parent.Version {
+		return reject("bad-version", "block version %d is below the version %d of the previous block", b.Version, parent.Version)
+	}
+
+	return checkTransactionVersions(b.Transactions, b.Version)
+}
+
+// checkTransactionVersions refuses version 0 transactions, whose ID can't
+// be checked, in blocks of version 2 on
+func checkTransactionVersions(txs []*Transaction, blockVersion int) error {
+	if blockVersion < 2 {
+		return nil
+	}
+
+	for _, tx := range txs {
+		if tx.Version == 0 {
+			return reject("bad-txns-version", "transaction %x of version 0 in a version %d block", tx.ID, blockVersion)
+		}
+	}
+
+	return nil
+}
+
+// checkBlock applies the rules a block must pass to be stored: a proof of
+// work over its transactions, none of them listed twice, a place right
+// after a stored block and a timestamp past the median time of its
+// ancestors but not too far in the future. Its transactions are validated
+// when it is connected to the UTXO set
+func checkBlock(tx StoreTx, b *block) error {
+	if b.Pruned || len(b.Transactions) == 0 {
+		return reject("bad-blk-length", "block has no transactions")
+	}
+
+	err := checkDuplicateTransactions(b.Transactions)
+	if err != nil {
+		return err
+	}
+
+	if !newPow(b).validateHash() {
+		return reject("high-hash", "block hash is not a valid proof of work over its data")
+	}
+
+	parent := blockInTx(tx, b.PrevBlockHash)
+	if parent == nil {
+		return reject("prev-blk-not-found", "previous block %x is not stored", b.PrevBlockHash)
+	}
+	if b.Height != parent.Height+1 {
+		return reject("bad-blk-height", "height %d doesn't follow the height %d of the previous block", b.Height, parent.Height)
+	}
+
+	if medianTime := medianTimePast(tx, parent); b.Timestamp <= medianTime {
+		return reject("time-too-old", "timestamp %d is not after the median time %d of the previous blocks", b.Timestamp, medianTime)
+	}
+	if maxTime := time.Now().Unix() + maxFutureBlockTime; b.Timestamp > maxTime {
+		return reject("time-too-new", "timestamp %d is more than %d seconds in the future", b.Timestamp, maxFutureBlockTime)
+	}
+
+	return nil
+}
+
+// checkDuplicateTransactions rejects a block listing a transaction twice.
+// The Merkle tree pairs the last node of an odd level with itself, so a
+// block repeating its last transactions has the same root and hash as the
+// valid one (CVE-2012-2459); stored first, it would keep the valid block out
+func checkDuplicateTransactions(txs []*Transaction) error {
+	seen := make(map[string]bool)
+	for _, tx := range txs {
+		id := hex.EncodeToString(tx.ID)
+		if seen[id] {
+			return reject("bad-txns-duplicate", "transaction %x is in the block more than once", tx.ID)
+		}
+		seen[id] = true
+	}
+
+	return nil
+}
+
+// medianTimePast returns the median timestamp of b and the ancestors before
+// it, up to medianTimeSpan blocks. A block must be timestamped after it, so
+// a miner can't move the time the time locks see backwards
+func medianTimePast(tx StoreTx, b *block) int64 {
+	var times []int64
+	for b != nil && len(times) < medianTimeSpan {
+		times = append(times, b.Timestamp)
+		if len(b.PrevBlockHash) == 0 {
+			break
+		}
+		b = blockInTx(tx, b.PrevBlockHash)
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	return times[len(times)/2]
+}
+
+// checkBlockTransactions applies the consensus rules to the transactions of
+// a block of version, verifying their signatures once all their scripts
+// pass. An output may only be spent once in the block, and the coinbase may
+// claim the reward and the fees of the other transactions but no more
+func checkBlockTransactions(view coinView, txs []*Transaction, height int, blockTime int64, version int) error {
+	err := checkCoinbasePosition(txs, version)
+	if err != nil {
+		return err
+	}
+
+	queue := &sigQueue{}
+	spent := make(map[string]bool)
+	fees, claimed := 0, 0
+
+	for i, tx := range txs {
+		queue.setTx(i)
+		fee, err := checkTransaction(view, tx, height, blockTime, queue)
+		if err == nil && !tx.isCoinbase() {
+			err = spendInputs(tx, spent)
+		}
+		if err != nil {
+			return fmt.Errorf("transaction %x: %w", tx.ID, err)
+		}
+
+		if tx.isCoinbase() {
+			for _, out := range tx.Vout {
+				claimed += out.Value
+			}
+		} else {
+			fees += fee
+		}
+	}
+
+	if claimed > reward+fees {
+		return reject("bad-cb-amount", "coinbase pays %d, more than the reward %d and the fees %d", claimed, reward, fees)
+	}
+
+	if bad := queue.verify(); bad >= 0 {
+		return fmt.Errorf("transaction %x: %w", txs[bad].ID, reject("bad-txns-signature", "input signatures do not verify"))
+	}
+
+	return nil
+}
+
+// checkCoinbasePosition requires exactly one coinbase, the first transaction
+// from version 2. The blocks mined before could have it last
+func checkCoinbasePosition(txs []*Transaction, version int) error {
+	position := -1
+	for i, tx := range txs {
+		if !tx.isCoinbase() {
+			continue
+		}
+		if position >= 0 {
+			return reject("bad-cb-multiple", "transactions %d and %d are both coinbases", position, i)
+		}
+		position = i
+	}
+
+	if position < 0 {
+		return reject("bad-cb-missing", "block has no coinbase")
+	}
+	if version >= 2 && position != 0 {
+		return reject("bad-cb-position", "the coinbase is transaction %d instead of the first", position)
+	}
+
+	return nil
+}
+
+// spendInputs records the outputs spent by tx in spent, refusing one an earlier transaction spent
+func spendInputs(tx *Transaction, spent map[string]bool) error {
+	for _, vin := range tx.Vin {
+		key := string(outpoint{vin.Txid, vin.Vout}.key())
+		if spent[key] {
+			return reject("bad-txns-inputs-missingorspent", "output %x:%d is spent by an earlier transaction of the block", vin.Txid, vin.Vout)
+		}
+		spent[key] = true
+	}
+
+	return nil
+}
+
+// checkTransaction applies every rule but the signature verification, the
+// signatures are queued on queue. It returns the fee of tx
+func checkTransaction(view coinView, tx *Transaction, height int, blockTime int64, queue *sigQueue) (int, error) {
+	err := tx.checkSanity()
+	if err != nil {
+		return 0, err
+	}
+
+	fee := 0
+	if !tx.isCoinbase() {
+		prevTXs, err := view.prevTransactions(tx)
+		if err != nil {
+			return 0, err
+		}
+
+		fee, err = tx.checkInputValues(prevTXs)
+		if err != nil {
+			return 0, err
+		}
+
+		if !tx.addSignatures(prevTXs, queue) {
+			return 0, reject("bad-txns-signature", "input scripts do not validate")
+		}
+	}
+
+	err = checkLocks(view, tx, height, blockTime)
+	if err != nil {
+		return 0, reject("non-final", "%s", err)
+	}
+
+	return fee, nil
+}
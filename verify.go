@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// The levels of verifychain, each one doing the checks of those below it
+const (
+	verifyHeaders    = iota // proof of work and hash linkage
+	verifyMerkle            // Merkle roots and transaction sanity
+	verifySignatures        // input values, locks and signatures
+	verifyUTXOSet           // UTXO set rebuilt from the blocks against the stored one
+)
+
+// inconsistentBlock reports the block where verifychain found a problem
+type inconsistentBlock struct {
+	Hash   []byte
+	Height int
+	Reason string
+}
+
+func (e *inconsistentBlock) Error() string {
+	return fmt.Sprintf("block %x at height %d: %s", e.Hash, e.Height, e.Reason)
+}
+
+func inconsistent(b *block, format string, args ...interface{}) error {
+	return &inconsistentBlock{b.Hash, b.Height, fmt.Sprintf(format, args...)}
+}
+
+// verifyResult counts what verifyChain checked
+type verifyResult struct {
+	Blocks  int    // blocks checked
+	Pruned  int    // of them, pruned blocks whose transactions couldn't be checked
+	Skipped int    // of them, blocks below the undo data the UTXO set is rolled back with, left out of the signature checks
+	UTXOs   int    // entries of the rebuilt UTXO set
+	Hash    []byte // of the UTXO set, see hashUTXOEntry
+}
+
+// verifyChain checks the last depth blocks of the active chain, all of them
+// if depth is 0, up to level. The blocks are checked oldest first, so an
+// *inconsistentBlock error names the lowest block found to be wrong
+func (bc *blockchain) verifyChain(depth, level int) (verifyResult, error) {
+	var result verifyResult
+
+	hashes, err := bc.lastBlockHashes(depth)
+	if err != nil {
+		return result, err
+	}
+
+	err = bc.db.View(func(tx StoreTx) error {
+		versionHeight := metaInt(tx, versionHeightKey)
+
+		var utxos *utxoOverlay
+		checkFrom := len(hashes)
+		if level >= verifySignatures {
+			var err error
+			utxos, checkFrom, err = bc.rollBackUTXOs(tx, hashes)
+			if err != nil {
+				return err
+			}
+		}
+
+		target := powTarget()
+		var parent *block
+		for i, hash := range hashes {
+			b := blockInTx(tx, hash)
+			if b == nil {
+				return fmt.Errorf("block %x is missing", hash)
+			}
+
+			if new(big.Int).SetBytes(b.Hash).Cmp(target) >= 0 {
+				return inconsistent(b, "the hash is above the proof of work target")
+			}
+
+			if level >= verifyMerkle {
+				err := bc.verifyBlockMerkle(b)
+				if err != nil {
+					return err
+				}
+
+				err = checkBlockVersion(b, parent, versionHeight)
+				if err != nil {
+					return inconsistent(b, "%s", err)
+				}
+			}
+
+			if level >= verifySignatures && !b.Pruned {
+				if i < checkFrom {
+					result.Skipped++
+				} else {
+					err := verifyBlockTransactions(tx, utxos, b)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			result.Blocks++
+			if b.Pruned {
+				result.Pruned++
+			}
+			parent = b
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if level >= verifyUTXOSet {
+		result.UTXOs, result.Hash, err = bc.verifyUTXOSet()
+	}
+
+	return result, err
+}
+
+// lastBlockHashes returns the hashes of the last depth blocks of the active
+// chain, oldest first, following the previous block hashes down from the
+// tip. Only the hashes are kept, the blocks are read again one at a time
+// as they are checked
+func (bc *blockchain) lastBlockHashes(depth int) ([][]byte, error) {
+	var hashes [][]byte
+	var child *block
+	hash := bc.tip
+
+	for depth == 0 || len(hashes) < depth {
+		b, err := bc.getBlock(hash)
+		if err != nil {
+			if child == nil {
+				return nil, fmt.Errorf("the tip %x is missing", hash)
+			}
+			return nil, inconsistent(child, "the previous block %x is missing", hash)
+		}
+
+		if !bytes.Equal(b.Hash, hash) {
+			return nil, inconsistent(b, "it is stored under the hash %x", hash)
+		}
+		if child != nil && b.Height != child.Height-1 {
+			return nil, inconsistent(child, "the previous block %x is at height %d", b.Hash, b.Height)
+		}
+		if (b.Height == 0) != (len(b.PrevBlockHash) == 0) {
+			return nil, inconsistent(b, "only the genesis block may have no previous block")
+		}
+
+		hashes = append(hashes, b.Hash)
+		if len(b.PrevBlockHash) == 0 {
+			break
+		}
+
+		child, hash = b, b.PrevBlockHash
+	}
+
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+
+	return hashes, nil
+}
+
+// verifyBlockMerkle checks that the hash of b is that of its header, with
+// the Merkle roots computed from its transactions, and their sanity
+func (bc *blockchain) verifyBlockMerkle(b *block) error {
+	if !b.Pruned && len(b.Transactions) == 0 {
+		return inconsistent(b, "it has no transactions")
+	}
+
+	err := checkDuplicateTransactions(b.Transactions)
+	if err != nil {
+		return inconsistent(b, "%s", err)
+	}
+
+	if !newPow(b).validateHash() {
+		return inconsistent(b, "the hash doesn't match the header and the Merkle roots of the transactions")
+	}
+
+	for _, tx := range b.Transactions {
+		err = tx.checkSanity()
+		if err != nil {
+			return inconsistent(b, "transaction %x: %s", tx.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// utxoOverlay is the stored UTXO set with changes kept in memory, for
+// moving it to another block without writing to the store
+type utxoOverlay struct {
+	base    StoreBucket
+	changes map[string][]byte // a nil value is a spent entry
+}
+
+func (u *utxoOverlay) Get(key []byte) []byte {
+	if data, ok := u.changes[string(key)]; ok {
+		return data
+	}
+
+	return u.base.Get(key)
+}
+
+func (u *utxoOverlay) Put(key, value []byte) error {
+	u.changes[string(key)] = value
+	return nil
+}
+
+func (u *utxoOverlay) Delete(key []byte) error {
+	u.changes[string(key)] = nil
+	return nil
+}
+
+// rollBackUTXOs rolls the UTXO set at the tip back over the blocks with
+// hashes, the last of which is the tip, using their undo data. It stops at
+// the first block without undo data, pruned or connected by a reindex, and
+// returns the index in hashes of the lowest block it rolled back over: the
+// UTXO set is at its previous block
+func (bc *blockchain) rollBackUTXOs(tx StoreTx, hashes [][]byte) (*utxoOverlay, int, error) {
+	if !bytes.Equal(hashes[len(hashes)-1], tipInTx(tx)) || !bc.indexSynced(tx, chainstate{}) {
+		return nil, 0, errors.New("the UTXO set is not in step with the tip, reindexutxo rebuilds it")
+	}
+
+	utxos := &utxoOverlay{tx.Bucket([]byte(utxoBucket)), make(map[string][]byte)}
+
+	i := len(hashes)
+	for ; i > 0; i-- {
+		b := blockInTx(tx, hashes[i-1])
+		if b == nil || b.Pruned {
+			break
+		}
+
+		undo, err := undoInTx(tx, b.Hash)
+		if err != nil {
+			return nil, 0, inconsistent(b, "%s", err)
+		}
+		if undo == nil {
+			break
+		}
+
+		err = disconnectUTXOs(utxos, b, *undo)
+		if err != nil {
+			return nil, 0, inconsistent(b, "%s", err)
+		}
+	}
+
+	return utxos, i, nil
+}
+
+// verifyBlockTransactions applies the consensus rules to the transactions
+// of b as when it was connected, against utxos at its previous block, then
+// connects it to utxos
+func verifyBlockTransactions(tx StoreTx, utxos *utxoOverlay, b *block) error {
+	err := checkBlockTransactions(utxoView{tx, utxos, b.PrevBlockHash}, b.Transactions, b.Height, b.Timestamp, b.Version)
+	if err == nil {
+		_, err = connectUTXOs(utxos, b)
+	}
+	if err != nil {
+		return inconsistent(b, "%s", err)
+	}
+
+	return nil
+}
+
+// verifyUTXOSet rebuilds the UTXO set from the blocks in memory and
+// compares its hash with that of the stored set. On a mismatch the first
+// differing outpoint names the block that created it
+func (bc *blockchain) verifyUTXOSet() (int, []byte, error) {
+	if height := bc.pruneHeight(); height > 0 {
+		return 0, nil, fmt.Errorf("can't rebuild the UTXO set, the blocks below height %d are pruned", height)
+	}
+
+	tip, err := bc.getBlock(bc.tip)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rebuilt := bc.findUTXO()
+	keys := make([]string, 0, len(rebuilt))
+	for key := range rebuilt {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := newUTXOHasher(tip.Hash, tip.Height)
+	for _, key := range keys {
+		err = hashUTXOEntry(h, []byte(key), rebuilt[key].serialize())
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	hash := h.Sum(nil)
+
+	var stored map[string]utxoEntry
+	err = bc.db.View(func(tx StoreTx) error {
+		if !bc.indexSynced(tx, chainstate{}) {
+			return errors.New("the UTXO set is not in step with the tip, reindexutxo rebuilds it")
+		}
+
+		bucket := tx.Bucket([]byte(utxoBucket))
+		storedHash, err := hashUTXOBucket(bucket, tip.Hash, tip.Height)
+		if err != nil || bytes.Equal(storedHash, hash) {
+			return err
+		}
+
+		stored = make(map[string]utxoEntry)
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			stored[string(k)], err = decodeUTXOEntry(v)
+			if err != nil {
+				return fmt.Errorf("the UTXO entry of %x is corrupt: %s", k, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil || stored == nil {
+		return len(rebuilt), hash, err
+	}
+
+	return len(rebuilt), hash, bc.firstUTXODifference(rebuilt, stored)
+}
+
+// firstUTXODifference reports the lowest block creating an output on which
+// the rebuilt and the stored UTXO sets differ
+func (bc *blockchain) firstUTXODifference(rebuilt, stored map[string]utxoEntry) error {
+	var first *inconsistentBlock
+
+	note := func(key string, height int, reason string) {
+		if first != nil && first.Height <= height {
+			return
+		}
+
+		o := outpointFromKey([]byte(key))
+		hash, _ := bc.getBlockHashAtHeight(height)
+		first = &inconsistentBlock{hash, height, fmt.Sprintf("output %x:%d %s", o.TxID, o.Vout, reason)}
+	}
+
+	for key, entry := range rebuilt {
+		storedEntry, ok := stored[key]
+		switch {
+		case !ok:
+			note(key, entry.Height, "is unspent but missing from the stored UTXO set")
+		case !bytes.Equal(entry.canonical(), storedEntry.canonical()):
+			note(key, entry.Height, "differs in the stored UTXO set")
+		}
+	}
+	for key, entry := range stored {
+		if _, ok := rebuilt[key]; !ok {
+			note(key, entry.Height, "is in the stored UTXO set but spent or never created")
+		}
+	}
+
+	if first == nil {
+		return errors.New("the UTXO set hashes differ but not its entries")
+	}
+
+	return first
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
@@ -16,18 +17,39 @@ const addressChecksumLen = 4
 type Wallet struct {
 	PublicKey  []byte
 	PrivateKey *ecdsa.PrivateKey
+	KeyType    keyType
+	Ed25519Key ed25519.PrivateKey // set instead of PrivateKey for Ed25519 wallets
 }
 
-func newWallet() *Wallet {
+func newWallet(kind keyType) *Wallet {
+	if kind == keyEd25519 {
+		private, public := newEd25519KeyPair()
+		return &Wallet{PublicKey: public, KeyType: keyEd25519, Ed25519Key: private}
+	}
+
 	private, public := newKeyPair()
-	wallet := Wallet{public, &private}
+	wallet := Wallet{PublicKey: public, PrivateKey: &private}
 	return &wallet
 }
 
 func (w Wallet) getAddress() []byte {
 	pubKeyHash := hashPubKey(w.PublicKey)
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+	return encodeAddress(w.KeyType.addressVersion(), pubKeyHash)
+}
+
+// sign signs data with the wallet key using the scheme of its key type
+func (w Wallet) sign(data []byte) []byte {
+	if w.KeyType == keyEd25519 {
+		return ed25519.Sign(w.Ed25519Key, data)
+	}
+
+	return signData(*w.PrivateKey, data)
+}
+
+// encodeAddress builds a base58check address from a version byte and a hash
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedPayload := append([]byte{version}, hash...)
 	checksum := checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checksum...)
@@ -36,6 +58,13 @@ func (w Wallet) getAddress() []byte {
 	return address
 }
 
+// decodeAddress returns the version byte and the hash of an address
+func decodeAddress(address []byte) (byte, []byte) {
+	payload := base58Decode(address)
+
+	return payload[0], payload[1 : len(payload)-addressChecksumLen]
+}
+
 func hashPubKey(pubKey []byte) []byte {
 	publicSHA256 := sha256.Sum256(pubKey)
 
@@ -72,7 +101,7 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pubKey := encodePubKey(private.PublicKey)
 
 	return *private, pubKey
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"encoding/gob"
 	"fmt"
@@ -20,7 +21,8 @@ type Wallets struct {
 
 type SerializableWallet struct {
 	PublicKey []byte
-	D         []byte
+	D         []byte // ECDSA private scalar or Ed25519 seed
+	KeyType   keyType
 }
 
 func newWallets(nodeID string) (*Wallets, error) {
@@ -31,8 +33,8 @@ func newWallets(nodeID string) (*Wallets, error) {
 	return &wallets, err
 }
 
-func (ws *Wallets) createWallet() string {
-	wallet := newWallet()
+func (ws *Wallets) createWallet(kind keyType) string {
+	wallet := newWallet(kind)
 	address := string(wallet.getAddress())
 	ws.Wallets[address] = wallet
 	return address
@@ -51,12 +53,32 @@ func (ws *Wallets) getWallet(address string) Wallet {
 	return *ws.Wallets[address]
 }
 
+// findWallet returns the wallet whose public key hashes to pubKeyHash or nil
+func (ws *Wallets) findWallet(pubKeyHash []byte) *Wallet {
+	for _, wallet := range ws.Wallets {
+		if bytes.Equal(hashPubKey(wallet.PublicKey), pubKeyHash) {
+			return wallet
+		}
+	}
+
+	return nil
+}
+
 func (ws *Wallets) saveToFile(nodeID string) {
 	walletFile := fmt.Sprintf(walletFile, nodeID)
 	var content bytes.Buffer
 	serializedWallets := make(map[string]SerializableWallet)
 
 	for address, wallet := range ws.Wallets {
+		if wallet.KeyType == keyEd25519 {
+			serializedWallets[address] = SerializableWallet{
+				PublicKey: wallet.PublicKey,
+				D:         wallet.Ed25519Key.Seed(),
+				KeyType:   keyEd25519,
+			}
+			continue
+		}
+
 		serializedWallets[address] = SerializableWallet{
 			PublicKey: wallet.PublicKey,
 			D:         wallet.PrivateKey.D.Bytes(),
@@ -95,6 +117,15 @@ func (ws *Wallets) loadFile(nodeID string) error {
 
 	wallets := make(map[string]*Wallet)
 	for address, sWallet := range serializedWallets {
+		if sWallet.KeyType == keyEd25519 {
+			wallets[address] = &Wallet{
+				PublicKey:  sWallet.PublicKey,
+				KeyType:    keyEd25519,
+				Ed25519Key: ed25519.NewKeyFromSeed(sWallet.D),
+			}
+			continue
+		}
+
 		privKey := &ecdsa.PrivateKey{
 			PublicKey: ecdsa.PublicKey{
 				Curve: elliptic.P256(),